@@ -1,30 +1,76 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"paxos/paxos/config"
 	"paxos/paxos/handlers"
 	"paxos/paxos/network"
+	"paxos/paxos/types"
+	"paxos/paxos/utils"
 )
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+
 	cfg := config.ParseFlags()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	if cfg.ProposalDelay > 0 {
-		time.Sleep(time.Duration(cfg.ProposalDelay) * time.Second)
+		select {
+		case <-time.After(time.Duration(cfg.ProposalDelay) * time.Second):
+		case <-ctx.Done():
+			os.Exit(1)
+		}
 	}
 
-	peer, err := network.NewPeer(cfg.HostsFile, cfg.ProposalValue)
+	cluster, err := config.LoadClusterConfig(cfg.HostsFile)
+	if err != nil {
+		log.Fatalf("Failed to load cluster config: %v", err)
+	}
+
+	peer, err := network.NewPeer(cfg, cluster)
 	if err != nil {
 		log.Fatalf("Failed to initialize peer: %v", err)
 	}
 
-	go peer.Start()
+	for _, value := range cfg.ProposalValues {
+		peer.Append(value)
+	}
+
+	go peer.Start(ctx)
 
 	mh := handlers.NewMessageHandler(peer)
-	mh.HandleMessages()
+	mh.HandleMessages(ctx)
 
+	if err := peer.Close(); err != nil {
+		fmt.Println("Error shutting down peer:", err)
+	}
+	if !chosen(peer) {
+		os.Exit(1)
+	}
+}
+
+// chosen reports whether a proposer saw all its values chosen and a learner
+// learned at least one value before shutting down. A peer that is only an
+// acceptor never learns what was chosen and always reports success.
+func chosen(peer *network.Peer) bool {
+	if peer.ProposerId != -1 && peer.Pending.Length() > 0 {
+		return false
+	}
+	if peer.ProposerId != -1 || peer.Roles.Contains(types.Learner) {
+		return utils.Length(&peer.Log) > 0
+	}
+	return true
 }
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"paxos/paxos/types"
+)
+
+// TagSize is the length of the HMAC-SHA256 tag appended to each message.
+const TagSize = sha256.Size
+
+// MinKeySize is the shortest cluster key accepted.
+const MinKeySize = 16
+
+// Authenticator signs outgoing messages with the cluster key and verifies
+// inbound ones. During a key rotation it holds two keys: messages are signed
+// with the first and accepted under either.
+type Authenticator struct {
+	keys [][]byte
+}
+
+func NewAuthenticator(keys [][]byte) (*Authenticator, error) {
+	if len(keys) == 0 || len(keys) > 2 {
+		return nil, fmt.Errorf("expected one or two cluster keys, got %d", len(keys))
+	}
+	for i, key := range keys {
+		if len(key) < MinKeySize {
+			return nil, fmt.Errorf("cluster key %d is %d bytes, need at least %d", i+1, len(key), MinKeySize)
+		}
+	}
+	return &Authenticator{keys: keys}, nil
+}
+
+// LoadAuthenticator reads hex-encoded cluster keys from path, one per line.
+// The first key signs; a second line holds the key being rotated in or out.
+func LoadAuthenticator(path string) (*Authenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cluster key file: %v", err)
+	}
+	defer file.Close()
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("cluster key %d is not valid hex: %v", len(keys)+1, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cluster key file: %v", err)
+	}
+	return NewAuthenticator(keys)
+}
+
+// Sign returns data with a tag over the message type, the sender's ID and
+// data appended.
+func (a *Authenticator) Sign(messageType types.MessageType, senderId int, data []byte) []byte {
+	return append(data[:len(data):len(data)], tag(a.keys[0], messageType, senderId, data)...)
+}
+
+// Verify checks the tag at the end of data against every active key and
+// returns data without it.
+func (a *Authenticator) Verify(messageType types.MessageType, senderId int, data []byte) ([]byte, error) {
+	if len(data) < TagSize {
+		return nil, fmt.Errorf("message is too short to carry an authentication tag")
+	}
+	payload, received := data[:len(data)-TagSize], data[len(data)-TagSize:]
+	for _, key := range a.keys {
+		if hmac.Equal(received, tag(key, messageType, senderId, payload)) {
+			return payload, nil
+		}
+	}
+	return nil, fmt.Errorf("authentication tag does not match any cluster key")
+}
+
+func tag(key []byte, messageType types.MessageType, senderId int, data []byte) []byte {
+	var header [5]byte
+	header[0] = byte(messageType)
+	binary.LittleEndian.PutUint32(header[1:], uint32(senderId))
+	mac := hmac.New(sha256.New, key)
+	mac.Write(header[:])
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"paxos/paxos/types"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{0x01}, MinKeySize)
+	newKey = bytes.Repeat([]byte{0x02}, 32)
+)
+
+func newAuthenticator(t *testing.T, keys ...[]byte) *Authenticator {
+	t.Helper()
+	a, err := NewAuthenticator(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestSignVerify(t *testing.T) {
+	a := newAuthenticator(t, oldKey)
+	for _, payload := range [][]byte{nil, []byte("accept 1.1 x")} {
+		signed := a.Sign(types.ACCEPT, 3, payload)
+		if len(signed) != len(payload)+TagSize {
+			t.Fatalf("signed message is %d bytes, want %d", len(signed), len(payload)+TagSize)
+		}
+		verified, err := a.Verify(types.ACCEPT, 3, signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(verified, payload) {
+			t.Errorf("Verify returned %q, want %q", verified, payload)
+		}
+	}
+}
+
+func TestSignDoesNotModifyPayload(t *testing.T) {
+	a := newAuthenticator(t, oldKey)
+	buffer := make([]byte, 4, 64)
+	copy(buffer, "data")
+	a.Sign(types.PREPARE, 1, buffer)
+	if spare := buffer[:8]; !bytes.Equal(spare[4:], make([]byte, 4)) {
+		t.Error("Sign wrote into the spare capacity of the payload")
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	a := newAuthenticator(t, oldKey)
+	signed := a.Sign(types.ACCEPT, 3, []byte("accept 1.1 x"))
+
+	tampered := append([]byte(nil), signed...)
+	tampered[0] ^= 0x01
+	if _, err := a.Verify(types.ACCEPT, 3, tampered); err == nil {
+		t.Error("accepted a message with a modified body")
+	}
+	tampered = append([]byte(nil), signed...)
+	tampered[len(tampered)-1] ^= 0x01
+	if _, err := a.Verify(types.ACCEPT, 3, tampered); err == nil {
+		t.Error("accepted a message with a modified tag")
+	}
+	if _, err := a.Verify(types.ACCEPT_ACK, 3, signed); err == nil {
+		t.Error("accepted a message under another message type")
+	}
+	if _, err := a.Verify(types.ACCEPT, 4, signed); err == nil {
+		t.Error("accepted a message from another sender")
+	}
+	if _, err := a.Verify(types.ACCEPT, 3, signed[:TagSize-1]); err == nil {
+		t.Error("accepted a message shorter than a tag")
+	}
+	if _, err := newAuthenticator(t, newKey).Verify(types.ACCEPT, 3, signed); err == nil {
+		t.Error("accepted a message signed with another key")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	before := newAuthenticator(t, oldKey)
+	during := newAuthenticator(t, newKey, oldKey)
+	after := newAuthenticator(t, newKey)
+
+	if _, err := during.Verify(types.LEARN, 2, before.Sign(types.LEARN, 2, []byte("x"))); err != nil {
+		t.Errorf("a rotating peer rejected a message signed with the old key: %v", err)
+	}
+	signed := during.Sign(types.LEARN, 2, []byte("x"))
+	if _, err := after.Verify(types.LEARN, 2, signed); err != nil {
+		t.Errorf("a rotating peer does not sign with the new key: %v", err)
+	}
+	if _, err := before.Verify(types.LEARN, 2, signed); err == nil {
+		t.Error("a rotating peer signed with the old key")
+	}
+}
+
+func TestNewAuthenticatorChecksKeys(t *testing.T) {
+	for _, keys := range [][][]byte{
+		nil,
+		{oldKey, newKey, oldKey},
+		{oldKey[:MinKeySize-1]},
+		{newKey, oldKey[:1]},
+	} {
+		if _, err := NewAuthenticator(keys); err == nil {
+			t.Errorf("NewAuthenticator accepted %d keys of lengths %v", len(keys), keyLengths(keys))
+		}
+	}
+}
+
+func keyLengths(keys [][]byte) []int {
+	lengths := make([]int, len(keys))
+	for i, key := range keys {
+		lengths[i] = len(key)
+	}
+	return lengths
+}
+
+func TestLoadAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster.key")
+	content := "\n0202020202020202020202020202020202020202020202020202020202020202\n  01010101010101010101010101010101  \n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := newAuthenticator(t, newKey).Sign(types.PREPARE, 1, []byte("x"))
+	if _, err := loaded.Verify(types.PREPARE, 1, signed); err != nil {
+		t.Errorf("loaded keys reject the first key's tag: %v", err)
+	}
+	signed = newAuthenticator(t, oldKey).Sign(types.PREPARE, 1, []byte("x"))
+	if _, err := loaded.Verify(types.PREPARE, 1, signed); err != nil {
+		t.Errorf("loaded keys reject the second key's tag: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not hex\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuthenticator(path); err == nil {
+		t.Error("loaded a key file that is not hex")
+	}
+}
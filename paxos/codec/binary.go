@@ -0,0 +1,226 @@
+package codec
+
+import (
+	"fmt"
+
+	"paxos/paxos/datastructures"
+	"paxos/paxos/types"
+)
+
+// BinaryCodec encodes a message's fields as little-endian int32s in a fixed
+// order, followed by its value if it has one.
+type BinaryCodec struct{}
+
+func encodeBinary(integers ...int) ([]byte, error) {
+	body := types.Serialize(integers...)
+	if body == nil && len(integers) > 0 {
+		return nil, fmt.Errorf("error encoding message fields")
+	}
+	return append(writeHeader(binaryCodecId), body...), nil
+}
+
+// decodeBinary returns the message's fields. Messages without a value must
+// have exactly fields integers; messages with one have at least that many.
+func decodeBinary(data []byte, fields int, hasValue bool) ([]int, error) {
+	body, err := readHeader(data, binaryCodecId)
+	if err != nil {
+		return nil, err
+	}
+	if len(body)%4 != 0 {
+		return nil, fmt.Errorf("message body of %d bytes is not a whole number of fields", len(body))
+	}
+	integers, err := types.Deserialize(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(integers) < fields || (!hasValue && len(integers) != fields) {
+		return nil, fmt.Errorf("message has %d fields, expected %d", len(integers), fields)
+	}
+	return integers, nil
+}
+
+func (BinaryCodec) EncodePrepare(message *types.PrepareMessage) ([]byte, error) {
+	return encodeBinary(append(
+		[]int{
+			message.Group.Get(),
+			message.Slot.Get(),
+			message.ProposalNumber.RoundNumber.Get(),
+			message.ProposalNumber.ServerId.Get(),
+		},
+		types.EncodeValue(message.ProposalValue.Get())...,
+	)...)
+}
+
+func (BinaryCodec) DecodePrepare(data []byte) (*types.PrepareMessage, error) {
+	integers, err := decodeBinary(data, 4, true)
+	if err != nil {
+		return nil, err
+	}
+	value, err := types.DecodeValue(integers[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &types.PrepareMessage{
+		Group:          datastructures.NewSafeValue(integers[0]),
+		Slot:           datastructures.NewSafeValue(integers[1]),
+		ProposalNumber: newProposalNumber(integers[2], integers[3]),
+		ProposalValue:  datastructures.NewSafeValue(value),
+	}, nil
+}
+
+func (BinaryCodec) EncodePrepareAck(message *types.PrepareAckMessage) ([]byte, error) {
+	noMoreAccepted := 0
+	if message.NoMoreAccepted.Get() {
+		noMoreAccepted = 1
+	}
+	return encodeBinary(append(
+		[]int{
+			message.Slot.Get(),
+			message.ProposalNumber.RoundNumber.Get(),
+			message.ProposalNumber.ServerId.Get(),
+			message.AcceptedProposalNumber.RoundNumber.Get(),
+			message.AcceptedProposalNumber.ServerId.Get(),
+			noMoreAccepted,
+		},
+		types.EncodeValue(message.AcceptedValue.Get())...,
+	)...)
+}
+
+func (BinaryCodec) DecodePrepareAck(data []byte) (*types.PrepareAckMessage, error) {
+	integers, err := decodeBinary(data, 6, true)
+	if err != nil {
+		return nil, err
+	}
+	value, err := types.DecodeValue(integers[6:])
+	if err != nil {
+		return nil, err
+	}
+	return &types.PrepareAckMessage{
+		Slot:                   datastructures.NewSafeValue(integers[0]),
+		ProposalNumber:         newProposalNumber(integers[1], integers[2]),
+		AcceptedProposalNumber: newProposalNumber(integers[3], integers[4]),
+		AcceptedValue:          datastructures.NewSafeValue(value),
+		NoMoreAccepted:         datastructures.NewSafeValue(integers[5] != 0),
+	}, nil
+}
+
+func (BinaryCodec) EncodeAccept(message *types.AcceptMessage) ([]byte, error) {
+	return encodeBinary(append(
+		[]int{
+			message.Group.Get(),
+			message.Slot.Get(),
+			message.ProposalNumber.RoundNumber.Get(),
+			message.ProposalNumber.ServerId.Get(),
+		},
+		types.EncodeValue(message.ProposalValue.Get())...,
+	)...)
+}
+
+func (BinaryCodec) DecodeAccept(data []byte) (*types.AcceptMessage, error) {
+	integers, err := decodeBinary(data, 4, true)
+	if err != nil {
+		return nil, err
+	}
+	value, err := types.DecodeValue(integers[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &types.AcceptMessage{
+		Group:          datastructures.NewSafeValue(integers[0]),
+		Slot:           datastructures.NewSafeValue(integers[1]),
+		ProposalNumber: newProposalNumber(integers[2], integers[3]),
+		ProposalValue:  datastructures.NewSafeValue(value),
+	}, nil
+}
+
+func (BinaryCodec) EncodeAcceptAck(message *types.AcceptAckMessage) ([]byte, error) {
+	return encodeBinary(
+		message.Slot.Get(),
+		message.ProposalNumber.RoundNumber.Get(),
+		message.ProposalNumber.ServerId.Get(),
+	)
+}
+
+func (BinaryCodec) DecodeAcceptAck(data []byte) (*types.AcceptAckMessage, error) {
+	integers, err := decodeBinary(data, 3, false)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AcceptAckMessage{
+		Slot:           datastructures.NewSafeValue(integers[0]),
+		ProposalNumber: newProposalNumber(integers[1], integers[2]),
+	}, nil
+}
+
+func (BinaryCodec) EncodePrepareNack(message *types.PrepareNackMessage) ([]byte, error) {
+	return encodeBinary(
+		message.Slot.Get(),
+		message.ProposalNumber.RoundNumber.Get(),
+		message.ProposalNumber.ServerId.Get(),
+		message.PromisedProposalNumber.RoundNumber.Get(),
+		message.PromisedProposalNumber.ServerId.Get(),
+	)
+}
+
+func (BinaryCodec) DecodePrepareNack(data []byte) (*types.PrepareNackMessage, error) {
+	integers, err := decodeBinary(data, 5, false)
+	if err != nil {
+		return nil, err
+	}
+	return &types.PrepareNackMessage{
+		Slot:                   datastructures.NewSafeValue(integers[0]),
+		ProposalNumber:         newProposalNumber(integers[1], integers[2]),
+		PromisedProposalNumber: newProposalNumber(integers[3], integers[4]),
+	}, nil
+}
+
+func (BinaryCodec) EncodeAcceptNack(message *types.AcceptNackMessage) ([]byte, error) {
+	return encodeBinary(
+		message.Slot.Get(),
+		message.ProposalNumber.RoundNumber.Get(),
+		message.ProposalNumber.ServerId.Get(),
+		message.PromisedProposalNumber.RoundNumber.Get(),
+		message.PromisedProposalNumber.ServerId.Get(),
+	)
+}
+
+func (BinaryCodec) DecodeAcceptNack(data []byte) (*types.AcceptNackMessage, error) {
+	integers, err := decodeBinary(data, 5, false)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AcceptNackMessage{
+		Slot:                   datastructures.NewSafeValue(integers[0]),
+		ProposalNumber:         newProposalNumber(integers[1], integers[2]),
+		PromisedProposalNumber: newProposalNumber(integers[3], integers[4]),
+	}, nil
+}
+
+func (BinaryCodec) EncodeLearn(message *types.LearnMessage) ([]byte, error) {
+	return encodeBinary(append(
+		[]int{
+			message.Group.Get(),
+			message.Slot.Get(),
+			message.ProposalNumber.RoundNumber.Get(),
+			message.ProposalNumber.ServerId.Get(),
+		},
+		types.EncodeValue(message.AcceptedValue.Get())...,
+	)...)
+}
+
+func (BinaryCodec) DecodeLearn(data []byte) (*types.LearnMessage, error) {
+	integers, err := decodeBinary(data, 4, true)
+	if err != nil {
+		return nil, err
+	}
+	value, err := types.DecodeValue(integers[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &types.LearnMessage{
+		Group:          datastructures.NewSafeValue(integers[0]),
+		Slot:           datastructures.NewSafeValue(integers[1]),
+		ProposalNumber: newProposalNumber(integers[2], integers[3]),
+		AcceptedValue:  datastructures.NewSafeValue(value),
+	}, nil
+}
@@ -0,0 +1,88 @@
+package codec
+
+import (
+	"fmt"
+
+	"paxos/paxos/datastructures"
+	"paxos/paxos/types"
+)
+
+// Version is the wire protocol version written into every encoded message.
+// Peers refuse messages carrying any other version. Version 2 added the
+// group to prepare and accept messages, version 3 the answered proposal
+// number to prepare acks.
+const Version = 3
+
+// Every encoded message starts with a 4-byte header: the magic bytes "PX",
+// the protocol version and the id of the codec that produced the body.
+const headerSize = 4
+
+var magic = [2]byte{'P', 'X'}
+
+const (
+	binaryCodecId byte = iota
+	jsonCodecId
+)
+
+var codecNames = map[byte]string{
+	binaryCodecId: "binary",
+	jsonCodecId:   "json",
+}
+
+type Codec interface {
+	EncodePrepare(message *types.PrepareMessage) ([]byte, error)
+	DecodePrepare(data []byte) (*types.PrepareMessage, error)
+	EncodePrepareAck(message *types.PrepareAckMessage) ([]byte, error)
+	DecodePrepareAck(data []byte) (*types.PrepareAckMessage, error)
+	EncodeAccept(message *types.AcceptMessage) ([]byte, error)
+	DecodeAccept(data []byte) (*types.AcceptMessage, error)
+	EncodeAcceptAck(message *types.AcceptAckMessage) ([]byte, error)
+	DecodeAcceptAck(data []byte) (*types.AcceptAckMessage, error)
+	EncodePrepareNack(message *types.PrepareNackMessage) ([]byte, error)
+	DecodePrepareNack(data []byte) (*types.PrepareNackMessage, error)
+	EncodeAcceptNack(message *types.AcceptNackMessage) ([]byte, error)
+	DecodeAcceptNack(data []byte) (*types.AcceptNackMessage, error)
+	EncodeLearn(message *types.LearnMessage) ([]byte, error)
+	DecodeLearn(data []byte) (*types.LearnMessage, error)
+}
+
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "binary":
+		return BinaryCodec{}, nil
+	case "json":
+		return JSONCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown codec: %s", name)
+	}
+}
+
+func writeHeader(codecId byte) []byte {
+	return []byte{magic[0], magic[1], Version, codecId}
+}
+
+// readHeader validates the header of an encoded message and returns its
+// body.
+func readHeader(data []byte, codecId byte) ([]byte, error) {
+	if len(data) < headerSize || data[0] != magic[0] || data[1] != magic[1] {
+		return nil, fmt.Errorf("message is missing the protocol header")
+	}
+	if data[2] != Version {
+		return nil, fmt.Errorf("unsupported protocol version %d, expected %d", data[2], Version)
+	}
+	if data[3] != codecId {
+		name, ok := codecNames[data[3]]
+		if !ok {
+			name = fmt.Sprintf("unknown (%d)", data[3])
+		}
+		return nil, fmt.Errorf("message encoded with %s codec, expected %s", name, codecNames[codecId])
+	}
+	return data[headerSize:], nil
+}
+
+func newProposalNumber(roundNumber, serverId int) *types.ProposalNumber {
+	return &types.ProposalNumber{
+		RoundNumber: datastructures.NewSafeValue(roundNumber),
+		ServerId:    datastructures.NewSafeValue(serverId),
+	}
+}
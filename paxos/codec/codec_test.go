@@ -0,0 +1,242 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"paxos/paxos/datastructures"
+	"paxos/paxos/types"
+)
+
+var codecs = map[string]Codec{
+	"binary": BinaryCodec{},
+	"json":   JSONCodec{},
+}
+
+// values covers nil and empty values, which the codecs do not tell apart,
+// and lengths that are not a whole number of binary fields.
+var values = [][]byte{nil, {}, []byte("X"), []byte("abcd"), []byte(`{"op":"set","key":"x"}`)}
+
+func equalProposalNumbers(a, b *types.ProposalNumber) bool {
+	return a.RoundNumber.Get() == b.RoundNumber.Get() && a.ServerId.Get() == b.ServerId.Get()
+}
+
+func TestPrepareRoundTrip(t *testing.T) {
+	for name, codec := range codecs {
+		for _, value := range values {
+			message := &types.PrepareMessage{
+				Group:          datastructures.NewSafeValue(2),
+				Slot:           datastructures.NewSafeValue(7),
+				ProposalNumber: newProposalNumber(3, 5),
+				ProposalValue:  datastructures.NewSafeValue(value),
+			}
+			data, err := codec.EncodePrepare(message)
+			if err != nil {
+				t.Fatalf("%s: encode: %v", name, err)
+			}
+			decoded, err := codec.DecodePrepare(data)
+			if err != nil {
+				t.Fatalf("%s: decode: %v", name, err)
+			}
+			if decoded.Group.Get() != 2 || decoded.Slot.Get() != 7 ||
+				!equalProposalNumbers(decoded.ProposalNumber, message.ProposalNumber) ||
+				!bytes.Equal(decoded.ProposalValue.Get(), value) {
+				t.Errorf("%s: prepare with value %q did not survive a round trip", name, value)
+			}
+		}
+	}
+}
+
+func TestPrepareAckRoundTrip(t *testing.T) {
+	for name, codec := range codecs {
+		for _, value := range values {
+			for _, noMoreAccepted := range []bool{false, true} {
+				message := &types.PrepareAckMessage{
+					Slot:                   datastructures.NewSafeValue(4),
+					ProposalNumber:         newProposalNumber(6, 1),
+					AcceptedProposalNumber: newProposalNumber(2, 3),
+					AcceptedValue:          datastructures.NewSafeValue(value),
+					NoMoreAccepted:         datastructures.NewSafeValue(noMoreAccepted),
+				}
+				data, err := codec.EncodePrepareAck(message)
+				if err != nil {
+					t.Fatalf("%s: encode: %v", name, err)
+				}
+				decoded, err := codec.DecodePrepareAck(data)
+				if err != nil {
+					t.Fatalf("%s: decode: %v", name, err)
+				}
+				if decoded.Slot.Get() != 4 ||
+					!equalProposalNumbers(decoded.ProposalNumber, message.ProposalNumber) ||
+					!equalProposalNumbers(decoded.AcceptedProposalNumber, message.AcceptedProposalNumber) ||
+					!bytes.Equal(decoded.AcceptedValue.Get(), value) ||
+					decoded.NoMoreAccepted.Get() != noMoreAccepted {
+					t.Errorf("%s: prepare_ack with value %q did not survive a round trip", name, value)
+				}
+			}
+		}
+	}
+}
+
+func TestAcceptRoundTrip(t *testing.T) {
+	for name, codec := range codecs {
+		for _, value := range values {
+			message := &types.AcceptMessage{
+				Group:          datastructures.NewSafeValue(1),
+				Slot:           datastructures.NewSafeValue(9),
+				ProposalNumber: newProposalNumber(1, 2),
+				ProposalValue:  datastructures.NewSafeValue(value),
+			}
+			data, err := codec.EncodeAccept(message)
+			if err != nil {
+				t.Fatalf("%s: encode: %v", name, err)
+			}
+			decoded, err := codec.DecodeAccept(data)
+			if err != nil {
+				t.Fatalf("%s: decode: %v", name, err)
+			}
+			if decoded.Group.Get() != 1 || decoded.Slot.Get() != 9 ||
+				!equalProposalNumbers(decoded.ProposalNumber, message.ProposalNumber) ||
+				!bytes.Equal(decoded.ProposalValue.Get(), value) {
+				t.Errorf("%s: accept with value %q did not survive a round trip", name, value)
+			}
+		}
+	}
+}
+
+func TestLearnRoundTrip(t *testing.T) {
+	for name, codec := range codecs {
+		for _, value := range values {
+			message := &types.LearnMessage{
+				Group:          datastructures.NewSafeValue(3),
+				Slot:           datastructures.NewSafeValue(2),
+				ProposalNumber: newProposalNumber(8, 4),
+				AcceptedValue:  datastructures.NewSafeValue(value),
+			}
+			data, err := codec.EncodeLearn(message)
+			if err != nil {
+				t.Fatalf("%s: encode: %v", name, err)
+			}
+			decoded, err := codec.DecodeLearn(data)
+			if err != nil {
+				t.Fatalf("%s: decode: %v", name, err)
+			}
+			if decoded.Group.Get() != 3 || decoded.Slot.Get() != 2 ||
+				!equalProposalNumbers(decoded.ProposalNumber, message.ProposalNumber) ||
+				!bytes.Equal(decoded.AcceptedValue.Get(), value) {
+				t.Errorf("%s: learn with value %q did not survive a round trip", name, value)
+			}
+		}
+	}
+}
+
+func TestAckAndNackRoundTrip(t *testing.T) {
+	for name, codec := range codecs {
+		acceptAck := &types.AcceptAckMessage{
+			Slot:           datastructures.NewSafeValue(5),
+			ProposalNumber: newProposalNumber(2, 1),
+		}
+		data, err := codec.EncodeAcceptAck(acceptAck)
+		if err != nil {
+			t.Fatalf("%s: encode accept_ack: %v", name, err)
+		}
+		decodedAck, err := codec.DecodeAcceptAck(data)
+		if err != nil {
+			t.Fatalf("%s: decode accept_ack: %v", name, err)
+		}
+		if decodedAck.Slot.Get() != 5 || !equalProposalNumbers(decodedAck.ProposalNumber, acceptAck.ProposalNumber) {
+			t.Errorf("%s: accept_ack did not survive a round trip", name)
+		}
+
+		prepareNack := &types.PrepareNackMessage{
+			Slot:                   datastructures.NewSafeValue(1),
+			ProposalNumber:         newProposalNumber(1, 1),
+			PromisedProposalNumber: newProposalNumber(4, 2),
+		}
+		data, err = codec.EncodePrepareNack(prepareNack)
+		if err != nil {
+			t.Fatalf("%s: encode prepare_nack: %v", name, err)
+		}
+		decodedPrepareNack, err := codec.DecodePrepareNack(data)
+		if err != nil {
+			t.Fatalf("%s: decode prepare_nack: %v", name, err)
+		}
+		if decodedPrepareNack.Slot.Get() != 1 ||
+			!equalProposalNumbers(decodedPrepareNack.ProposalNumber, prepareNack.ProposalNumber) ||
+			!equalProposalNumbers(decodedPrepareNack.PromisedProposalNumber, prepareNack.PromisedProposalNumber) {
+			t.Errorf("%s: prepare_nack did not survive a round trip", name)
+		}
+
+		acceptNack := &types.AcceptNackMessage{
+			Slot:                   datastructures.NewSafeValue(3),
+			ProposalNumber:         newProposalNumber(2, 2),
+			PromisedProposalNumber: newProposalNumber(5, 1),
+		}
+		data, err = codec.EncodeAcceptNack(acceptNack)
+		if err != nil {
+			t.Fatalf("%s: encode accept_nack: %v", name, err)
+		}
+		decodedAcceptNack, err := codec.DecodeAcceptNack(data)
+		if err != nil {
+			t.Fatalf("%s: decode accept_nack: %v", name, err)
+		}
+		if decodedAcceptNack.Slot.Get() != 3 ||
+			!equalProposalNumbers(decodedAcceptNack.ProposalNumber, acceptNack.ProposalNumber) ||
+			!equalProposalNumbers(decodedAcceptNack.PromisedProposalNumber, acceptNack.PromisedProposalNumber) {
+			t.Errorf("%s: accept_nack did not survive a round trip", name)
+		}
+	}
+}
+
+func TestRejectsForeignHeaders(t *testing.T) {
+	message := &types.AcceptAckMessage{
+		Slot:           datastructures.NewSafeValue(1),
+		ProposalNumber: newProposalNumber(1, 1),
+	}
+	data, err := BinaryCodec{}.EncodeAcceptAck(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (JSONCodec{}).DecodeAcceptAck(data); err == nil {
+		t.Error("json codec decoded a binary message")
+	}
+	data[2] = Version + 1
+	if _, err := (BinaryCodec{}).DecodeAcceptAck(data); err == nil {
+		t.Error("binary codec decoded a message of another protocol version")
+	}
+	if _, err := (BinaryCodec{}).DecodeAcceptAck([]byte("junk")); err == nil {
+		t.Error("binary codec decoded a message without a header")
+	}
+}
+
+func TestBinaryRejectsTruncatedMessages(t *testing.T) {
+	data, err := BinaryCodec{}.EncodeAccept(&types.AcceptMessage{
+		Group:          datastructures.NewSafeValue(1),
+		Slot:           datastructures.NewSafeValue(1),
+		ProposalNumber: newProposalNumber(1, 1),
+		ProposalValue:  datastructures.NewSafeValue([]byte("value")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for length := headerSize; length < len(data); length++ {
+		if _, err := (BinaryCodec{}).DecodeAccept(data[:length]); err == nil {
+			t.Errorf("decoded an accept truncated to %d of %d bytes", length, len(data))
+		}
+	}
+}
+
+func TestJSONRejectsNullFields(t *testing.T) {
+	for _, body := range []string{
+		`{"group":1,"slot":1,"proposal_number":null,"proposal_value":"eA=="}`,
+		`{"group":1,"slot":1,"proposal_number":{"round_number":null,"server_id":1},"proposal_value":"eA=="}`,
+		`{"group":1,"slot":null,"proposal_number":{"round_number":1,"server_id":1},"proposal_value":"eA=="}`,
+		`{"group":1,"slot":1,"proposal_number":{"round_number":1,"server_id":1},"proposal_value":null}`,
+	} {
+		data := append(writeHeader(jsonCodecId), body...)
+		if _, err := (JSONCodec{}).DecodeAccept(data); err == nil || !strings.Contains(err.Error(), "null") {
+			t.Errorf("decoding %s: got error %v, want a null field error", body, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"paxos/paxos/datastructures"
+	"paxos/paxos/types"
+)
+
+// JSONCodec encodes messages as JSON objects. It is larger and slower than
+// BinaryCodec and is meant for debugging.
+type JSONCodec struct{}
+
+func encodeJSON(message any) ([]byte, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return append(writeHeader(jsonCodecId), body...), nil
+}
+
+// decodeJSON unmarshals into a message whose fields are already allocated,
+// so that fields missing from the JSON keep their zero values. A field set
+// to null would be left nil, so such messages are rejected.
+func decodeJSON[T any](data []byte, message *T) (*T, error) {
+	body, err := readHeader(data, jsonCodecId)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, message); err != nil {
+		return nil, err
+	}
+	if err := checkFields(reflect.ValueOf(message).Elem()); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// checkFields returns an error if any pointer field of message, or of the
+// structs it points to, is nil.
+func checkFields(message reflect.Value) error {
+	for i := 0; i < message.NumField(); i++ {
+		field := message.Field(i)
+		if field.Kind() != reflect.Pointer {
+			continue
+		}
+		name := message.Type().Field(i).Tag.Get("json")
+		if field.IsNil() {
+			return fmt.Errorf("message field %s is null", name)
+		}
+		if field.Elem().Kind() == reflect.Struct {
+			if err := checkFields(field.Elem()); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// nonNull returns value, or an empty value in place of nil, which would be
+// encoded as null.
+func nonNull(value *datastructures.SafeValue[[]byte]) *datastructures.SafeValue[[]byte] {
+	if value.Get() == nil {
+		return datastructures.NewSafeValue([]byte{})
+	}
+	return value
+}
+
+func (JSONCodec) EncodePrepare(message *types.PrepareMessage) ([]byte, error) {
+	encoded := *message
+	encoded.ProposalValue = nonNull(message.ProposalValue)
+	return encodeJSON(&encoded)
+}
+
+func (JSONCodec) DecodePrepare(data []byte) (*types.PrepareMessage, error) {
+	return decodeJSON(data, &types.PrepareMessage{
+		Group:          datastructures.NewSafeValue(0),
+		Slot:           datastructures.NewSafeValue(0),
+		ProposalNumber: newProposalNumber(0, 0),
+		ProposalValue:  datastructures.NewSafeValue[[]byte](nil),
+	})
+}
+
+func (JSONCodec) EncodePrepareAck(message *types.PrepareAckMessage) ([]byte, error) {
+	encoded := *message
+	encoded.AcceptedValue = nonNull(message.AcceptedValue)
+	return encodeJSON(&encoded)
+}
+
+func (JSONCodec) DecodePrepareAck(data []byte) (*types.PrepareAckMessage, error) {
+	return decodeJSON(data, &types.PrepareAckMessage{
+		Slot:                   datastructures.NewSafeValue(0),
+		ProposalNumber:         newProposalNumber(0, 0),
+		AcceptedProposalNumber: newProposalNumber(0, 0),
+		AcceptedValue:          datastructures.NewSafeValue[[]byte](nil),
+		NoMoreAccepted:         datastructures.NewSafeValue(false),
+	})
+}
+
+func (JSONCodec) EncodeAccept(message *types.AcceptMessage) ([]byte, error) {
+	encoded := *message
+	encoded.ProposalValue = nonNull(message.ProposalValue)
+	return encodeJSON(&encoded)
+}
+
+func (JSONCodec) DecodeAccept(data []byte) (*types.AcceptMessage, error) {
+	return decodeJSON(data, &types.AcceptMessage{
+		Group:          datastructures.NewSafeValue(0),
+		Slot:           datastructures.NewSafeValue(0),
+		ProposalNumber: newProposalNumber(0, 0),
+		ProposalValue:  datastructures.NewSafeValue[[]byte](nil),
+	})
+}
+
+func (JSONCodec) EncodeAcceptAck(message *types.AcceptAckMessage) ([]byte, error) {
+	return encodeJSON(message)
+}
+
+func (JSONCodec) DecodeAcceptAck(data []byte) (*types.AcceptAckMessage, error) {
+	return decodeJSON(data, &types.AcceptAckMessage{
+		Slot:           datastructures.NewSafeValue(0),
+		ProposalNumber: newProposalNumber(0, 0),
+	})
+}
+
+func (JSONCodec) EncodePrepareNack(message *types.PrepareNackMessage) ([]byte, error) {
+	return encodeJSON(message)
+}
+
+func (JSONCodec) DecodePrepareNack(data []byte) (*types.PrepareNackMessage, error) {
+	return decodeJSON(data, &types.PrepareNackMessage{
+		Slot:                   datastructures.NewSafeValue(0),
+		ProposalNumber:         newProposalNumber(0, 0),
+		PromisedProposalNumber: newProposalNumber(0, 0),
+	})
+}
+
+func (JSONCodec) EncodeAcceptNack(message *types.AcceptNackMessage) ([]byte, error) {
+	return encodeJSON(message)
+}
+
+func (JSONCodec) DecodeAcceptNack(data []byte) (*types.AcceptNackMessage, error) {
+	return decodeJSON(data, &types.AcceptNackMessage{
+		Slot:                   datastructures.NewSafeValue(0),
+		ProposalNumber:         newProposalNumber(0, 0),
+		PromisedProposalNumber: newProposalNumber(0, 0),
+	})
+}
+
+func (JSONCodec) EncodeLearn(message *types.LearnMessage) ([]byte, error) {
+	encoded := *message
+	encoded.AcceptedValue = nonNull(message.AcceptedValue)
+	return encodeJSON(&encoded)
+}
+
+func (JSONCodec) DecodeLearn(data []byte) (*types.LearnMessage, error) {
+	return decodeJSON(data, &types.LearnMessage{
+		Group:          datastructures.NewSafeValue(0),
+		Slot:           datastructures.NewSafeValue(0),
+		ProposalNumber: newProposalNumber(0, 0),
+		AcceptedValue:  datastructures.NewSafeValue[[]byte](nil),
+	})
+}
@@ -0,0 +1,419 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"paxos/paxos/types"
+	"paxos/paxos/utils"
+)
+
+// DefaultPort is the port of a peer whose entry does not name one.
+const DefaultPort = 8080
+
+// ClusterConfig describes every peer of the cluster and the proposer
+// groups they belong to. Peers are ordered by ID, starting at 1.
+type ClusterConfig struct {
+	Peers          []PeerConfig  `json:"peers"`
+	Groups         []GroupConfig `json:"groups"`
+	PrepareTimeout Duration      `json:"prepare_timeout,omitempty"`
+	AcceptTimeout  Duration      `json:"accept_timeout,omitempty"`
+	BackoffMin     Duration      `json:"backoff_min,omitempty"`
+	BackoffMax     Duration      `json:"backoff_max,omitempty"`
+}
+
+type PeerConfig struct {
+	Id   int    `json:"id,omitempty"`
+	Name string `json:"name"`
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+// GroupConfig lists the peers playing each role for one proposer group.
+// Zero quorum sizes fall back to the command line, then to a majority.
+// ProposerIds gives proposers the identity used in their proposal numbers;
+// a proposer without one uses its peer ID.
+type GroupConfig struct {
+	Id            int            `json:"id"`
+	Proposers     []string       `json:"proposers,omitempty"`
+	ProposerIds   map[string]int `json:"proposer_ids,omitempty"`
+	Acceptors     []string       `json:"acceptors,omitempty"`
+	Learners      []string       `json:"learners,omitempty"`
+	PrepareQuorum int            `json:"prepare_quorum,omitempty"`
+	AcceptQuorum  int            `json:"accept_quorum,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "2s" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"2s\": %v", err)
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadClusterConfig reads a JSON cluster description, or a legacy hosts
+// file of name[@host[:port]]:roles lines, and validates it.
+func LoadClusterConfig(path string) (*ClusterConfig, error) {
+	cluster, problems := CheckClusterConfig(path, 0, 0)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	for i := range cluster.Peers {
+		cluster.Peers[i].Id = i + 1
+	}
+	return cluster, nil
+}
+
+// CheckClusterConfig reads the cluster description at path and reports
+// every problem in it rather than only the first. prepareQuorum and
+// acceptQuorum are the command line quorum sizes, 0 if unset.
+func CheckClusterConfig(path string, prepareQuorum, acceptQuorum int) (*ClusterConfig, []error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	var cluster *ClusterConfig
+	var problems []error
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		cluster, err = ParseClusterJSON(content)
+		if err != nil {
+			return nil, []error{err}
+		}
+	} else {
+		cluster, problems = parseHostsFile(content)
+	}
+	return cluster, append(problems, cluster.Problems(prepareQuorum, acceptQuorum)...)
+}
+
+// ParseClusterJSON decodes a JSON cluster description. Peers with explicit
+// IDs are put in ID order; peers without are numbered in file order.
+func ParseClusterJSON(content []byte) (*ClusterConfig, error) {
+	cluster := &ClusterConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cluster); err != nil {
+		return nil, fmt.Errorf("invalid cluster config: %v", err)
+	}
+	sort.SliceStable(cluster.Peers, func(i, j int) bool {
+		return cluster.Peers[i].Id < cluster.Peers[j].Id
+	})
+	for i := range cluster.Peers {
+		peer := &cluster.Peers[i]
+		if peer.Host == "" {
+			peer.Host = peer.Name
+		}
+		if peer.Port == 0 {
+			peer.Port = DefaultPort
+		}
+	}
+	return cluster, nil
+}
+
+// ParseHostsFile converts a legacy hosts file into a ClusterConfig. Peers
+// are numbered in file order.
+func ParseHostsFile(content []byte) (*ClusterConfig, error) {
+	cluster, problems := parseHostsFile(content)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return cluster, nil
+}
+
+// parseHostsFile parses every line it can, skipping unparsable lines and
+// roles and reporting each of them.
+func parseHostsFile(content []byte) (*ClusterConfig, []error) {
+	cluster := &ClusterConfig{}
+	groups := make(map[int]*GroupConfig)
+	var problems []error
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		peer, roles, err := parseHostsLine(line)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("line %d: %v", i+1, err))
+			continue
+		}
+		cluster.Peers = append(cluster.Peers, peer)
+		for _, role := range roles {
+			roleName, groupId, proposerId, err := parseRole(role)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("line %d: %v", i+1, err))
+				continue
+			}
+			group, ok := groups[groupId]
+			if !ok {
+				group = &GroupConfig{Id: groupId}
+				groups[groupId] = group
+			}
+			switch roleName {
+			case "proposer":
+				group.Proposers = append(group.Proposers, peer.Name)
+				if proposerId != 0 {
+					if group.ProposerIds == nil {
+						group.ProposerIds = make(map[string]int)
+					}
+					group.ProposerIds[peer.Name] = proposerId
+				}
+			case "acceptor":
+				group.Acceptors = append(group.Acceptors, peer.Name)
+			case "learner":
+				group.Learners = append(group.Learners, peer.Name)
+			}
+		}
+	}
+	for _, group := range groups {
+		cluster.Groups = append(cluster.Groups, *group)
+	}
+	sort.Slice(cluster.Groups, func(i, j int) bool {
+		return cluster.Groups[i].Id < cluster.Groups[j].Id
+	})
+	return cluster, problems
+}
+
+// parseHostsLine splits name:roles or name@host[:port]:roles. Without an
+// address the peer is reached at its name on DefaultPort.
+func parseHostsLine(line string) (PeerConfig, []string, error) {
+	separator := strings.LastIndex(line, ":")
+	if separator == -1 {
+		return PeerConfig{}, nil, fmt.Errorf("invalid format in hostsfile: %s", line)
+	}
+	name, roles := line[:separator], line[separator+1:]
+	peer := PeerConfig{Name: name, Host: name, Port: DefaultPort}
+	if peerName, address, found := strings.Cut(name, "@"); found {
+		peer.Name = peerName
+		peer.Host = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+		if host, portStr, err := net.SplitHostPort(address); err == nil {
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				return PeerConfig{}, nil, fmt.Errorf("invalid port in hostsfile: %s", line)
+			}
+			peer.Host = host
+			peer.Port = port
+		}
+	}
+	if peer.Name == "" || peer.Host == "" {
+		return PeerConfig{}, nil, fmt.Errorf("invalid format in hostsfile: %s", line)
+	}
+	return peer, strings.Split(roles, ","), nil
+}
+
+// parseRole splits a role such as acceptor2 into its name and group. A
+// proposer role may also name the proposer's identity within its group, as
+// in proposer1.2; the returned identity is 0 if it does not.
+func parseRole(role string) (string, int, int, error) {
+	for _, roleName := range []string{"proposer", "acceptor", "learner"} {
+		if strings.HasPrefix(role, roleName) {
+			groupIdStr, proposerIdStr, hasProposerId := strings.Cut(strings.TrimPrefix(role, roleName), ".")
+			groupId, err := strconv.Atoi(groupIdStr)
+			if err != nil || groupId < 0 {
+				return "", 0, 0, fmt.Errorf("invalid %s group id: %s", roleName, groupIdStr)
+			}
+			if !hasProposerId {
+				return roleName, groupId, 0, nil
+			}
+			if roleName != "proposer" {
+				return "", 0, 0, fmt.Errorf("only proposers take an identity: %s", role)
+			}
+			proposerId, err := strconv.Atoi(proposerIdStr)
+			if err != nil || proposerId <= 0 {
+				return "", 0, 0, fmt.Errorf("invalid proposer identity: %s", proposerIdStr)
+			}
+			return roleName, groupId, proposerId, nil
+		}
+	}
+	return "", 0, 0, fmt.Errorf("unknown role: %s", role)
+}
+
+// Validate returns every problem found by Problems, using the quorum sizes
+// set in the cluster config.
+func (cc *ClusterConfig) Validate() error {
+	return errors.Join(cc.Problems(0, 0)...)
+}
+
+// Problems checks that peers are uniquely named, numbered and addressed,
+// that groups only refer to known peers, that every group a proposer or
+// learner belongs to has acceptors, that no peer proposes for more than one
+// group, and that every group's quorums intersect. Group quorum sizes fall
+// back to prepareQuorum and acceptQuorum.
+func (cc *ClusterConfig) Problems(prepareQuorum, acceptQuorum int) []error {
+	var problems []error
+	if len(cc.Peers) == 0 {
+		problems = append(problems, fmt.Errorf("cluster has no peers"))
+	}
+	names := make(map[string]bool)
+	addresses := make(map[string]string)
+	for i, peer := range cc.Peers {
+		if peer.Name == "" {
+			problems = append(problems, fmt.Errorf("peer %d has no name", i+1))
+		} else if names[peer.Name] {
+			problems = append(problems, fmt.Errorf("duplicate peer: %s", peer.Name))
+		}
+		names[peer.Name] = true
+		if peer.Id != 0 && peer.Id != i+1 {
+			problems = append(problems, fmt.Errorf("peer IDs must be unique and run from 1 to %d, found %d for %s", len(cc.Peers), peer.Id, peer.Name))
+		}
+		if peer.Port <= 0 || peer.Port > 65535 {
+			problems = append(problems, fmt.Errorf("invalid port %d for peer %s", peer.Port, peer.Name))
+			continue
+		}
+		address := net.JoinHostPort(peer.Host, strconv.Itoa(peer.Port))
+		if other, ok := addresses[address]; ok && other != peer.Name {
+			problems = append(problems, fmt.Errorf("peers %s and %s share the address %s", other, peer.Name, address))
+		}
+		addresses[address] = peer.Name
+	}
+
+	for _, peer := range cc.Peers {
+		if groups := cc.GroupsForRole(peer.Name, types.Proposer); len(groups) > 1 {
+			problems = append(problems, fmt.Errorf("peer %s proposes for groups %v, but a peer can propose for only one group", peer.Name, groups))
+		}
+	}
+
+	groups := make(map[int]bool)
+	for _, group := range cc.Groups {
+		if group.Id < 0 {
+			problems = append(problems, fmt.Errorf("invalid group id: %d", group.Id))
+		}
+		if groups[group.Id] {
+			problems = append(problems, fmt.Errorf("duplicate group: %d", group.Id))
+		}
+		groups[group.Id] = true
+		for _, members := range [][]string{group.Proposers, group.Acceptors, group.Learners} {
+			for _, member := range members {
+				if !names[member] {
+					problems = append(problems, fmt.Errorf("group %d refers to unknown peer: %s", group.Id, member))
+				}
+			}
+		}
+		proposerIds := make(map[int]string)
+		for _, proposer := range group.Proposers {
+			proposerId := cc.ProposerId(group, proposer)
+			if other, ok := proposerIds[proposerId]; ok && other != proposer {
+				problems = append(problems, fmt.Errorf("proposers %s and %s of group %d share the identity %d", other, proposer, group.Id, proposerId))
+			}
+			proposerIds[proposerId] = proposer
+		}
+		for proposer, proposerId := range group.ProposerIds {
+			if !contains(group.Proposers, proposer) {
+				problems = append(problems, fmt.Errorf("group %d gives an identity to %s, which is not one of its proposers", group.Id, proposer))
+			}
+			if proposerId <= 0 {
+				problems = append(problems, fmt.Errorf("invalid identity %d for proposer %s of group %d", proposerId, proposer, group.Id))
+			}
+		}
+		if len(group.Learners) > 0 && len(group.Proposers) == 0 {
+			problems = append(problems, fmt.Errorf("learners %v belong to group %d, which has no proposers", group.Learners, group.Id))
+		}
+		if len(group.Acceptors) == 0 {
+			if len(group.Proposers) > 0 {
+				problems = append(problems, fmt.Errorf("group %d has proposers %v but no acceptors", group.Id, group.Proposers))
+			}
+			continue
+		}
+		groupPrepareQuorum, groupAcceptQuorum := group.PrepareQuorum, group.AcceptQuorum
+		if groupPrepareQuorum == 0 {
+			groupPrepareQuorum = prepareQuorum
+		}
+		if groupAcceptQuorum == 0 {
+			groupAcceptQuorum = acceptQuorum
+		}
+		prepareQuorumSize := utils.GetQuorumSize(len(group.Acceptors), groupPrepareQuorum)
+		acceptQuorumSize := utils.GetQuorumSize(len(group.Acceptors), groupAcceptQuorum)
+		if err := utils.ValidateQuorums(len(group.Acceptors), prepareQuorumSize, acceptQuorumSize); err != nil {
+			problems = append(problems, fmt.Errorf("invalid quorums for group %d: %v", group.Id, err))
+		}
+	}
+	return problems
+}
+
+// PeerNames returns the name of every peer, in ID order.
+func (cc *ClusterConfig) PeerNames() []string {
+	names := make([]string, len(cc.Peers))
+	for i, peer := range cc.Peers {
+		names[i] = peer.Name
+	}
+	return names
+}
+
+func (cc *ClusterConfig) Group(id int) (GroupConfig, bool) {
+	for _, group := range cc.Groups {
+		if group.Id == id {
+			return group, true
+		}
+	}
+	return GroupConfig{}, false
+}
+
+// ProposerId returns the identity proposer uses in its proposal numbers
+// for group: the one the group gives it, or else its peer ID.
+func (cc *ClusterConfig) ProposerId(group GroupConfig, proposer string) int {
+	if proposerId, ok := group.ProposerIds[proposer]; ok {
+		return proposerId
+	}
+	id, _ := utils.GetPeerIdFromName(proposer, cc.PeerNames())
+	return id
+}
+
+// GroupsForRole returns the groups in which peer plays role.
+func (cc *ClusterConfig) GroupsForRole(peer string, role types.Role) []int {
+	var groups []int
+	for _, group := range cc.Groups {
+		if contains(group.members(role), peer) {
+			groups = append(groups, group.Id)
+		}
+	}
+	return groups
+}
+
+// Roles returns the roles peer plays in any group.
+func (cc *ClusterConfig) Roles(peer string) []types.Role {
+	var roles []types.Role
+	for _, role := range []types.Role{types.Proposer, types.Acceptor, types.Learner} {
+		if len(cc.GroupsForRole(peer, role)) > 0 {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func (gc GroupConfig) members(role types.Role) []string {
+	switch role {
+	case types.Proposer:
+		return gc.Proposers
+	case types.Acceptor:
+		return gc.Acceptors
+	default:
+		return gc.Learners
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHostsLine(t *testing.T) {
+	for _, test := range []struct {
+		line  string
+		peer  PeerConfig
+		roles []string
+	}{
+		{"peer1:proposer1", PeerConfig{Name: "peer1", Host: "peer1", Port: DefaultPort}, []string{"proposer1"}},
+		{"peer1:acceptor1,learner2", PeerConfig{Name: "peer1", Host: "peer1", Port: DefaultPort}, []string{"acceptor1", "learner2"}},
+		{"peer1@10.0.0.1:acceptor1", PeerConfig{Name: "peer1", Host: "10.0.0.1", Port: DefaultPort}, []string{"acceptor1"}},
+		{"peer1@10.0.0.1:9000:acceptor1", PeerConfig{Name: "peer1", Host: "10.0.0.1", Port: 9000}, []string{"acceptor1"}},
+		{"peer1@db.example:9000:acceptor1", PeerConfig{Name: "peer1", Host: "db.example", Port: 9000}, []string{"acceptor1"}},
+		{"peer1@[::1]:acceptor1", PeerConfig{Name: "peer1", Host: "::1", Port: DefaultPort}, []string{"acceptor1"}},
+		{"peer1@[::1]:9000:acceptor1", PeerConfig{Name: "peer1", Host: "::1", Port: 9000}, []string{"acceptor1"}},
+	} {
+		peer, roles, err := parseHostsLine(test.line)
+		if err != nil {
+			t.Errorf("parseHostsLine(%q): %v", test.line, err)
+			continue
+		}
+		if peer != test.peer || !reflect.DeepEqual(roles, test.roles) {
+			t.Errorf("parseHostsLine(%q) = %+v, %v; want %+v, %v", test.line, peer, roles, test.peer, test.roles)
+		}
+	}
+}
+
+func TestParseHostsLineErrors(t *testing.T) {
+	for _, line := range []string{
+		"peer1",
+		":acceptor1",
+		"@10.0.0.1:acceptor1",
+		"peer1@:acceptor1",
+		"peer1@10.0.0.1:port:acceptor1",
+	} {
+		if peer, roles, err := parseHostsLine(line); err == nil {
+			t.Errorf("parseHostsLine(%q) = %+v, %v; want an error", line, peer, roles)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, test := range []struct {
+		role       string
+		name       string
+		group      int
+		proposerId int
+	}{
+		{"proposer1", "proposer", 1, 0},
+		{"proposer0", "proposer", 0, 0},
+		{"proposer12.3", "proposer", 12, 3},
+		{"acceptor2", "acceptor", 2, 0},
+		{"learner7", "learner", 7, 0},
+	} {
+		name, group, proposerId, err := parseRole(test.role)
+		if err != nil {
+			t.Errorf("parseRole(%q): %v", test.role, err)
+			continue
+		}
+		if name != test.name || group != test.group || proposerId != test.proposerId {
+			t.Errorf("parseRole(%q) = %s, %d, %d; want %s, %d, %d", test.role, name, group, proposerId, test.name, test.group, test.proposerId)
+		}
+	}
+}
+
+func TestParseRoleErrors(t *testing.T) {
+	for role, want := range map[string]string{
+		"proposer":     "invalid proposer group id: ",
+		"acceptorx":    "invalid acceptor group id: x",
+		"learner-1":    "invalid learner group id: -1",
+		"acceptor1.2":  "only proposers take an identity: acceptor1.2",
+		"proposer1.0":  "invalid proposer identity: 0",
+		"proposer1.":   "invalid proposer identity: ",
+		"coordinator1": "unknown role: coordinator1",
+	} {
+		if _, _, _, err := parseRole(role); err == nil || err.Error() != want {
+			t.Errorf("parseRole(%q) returned error %v, want %q", role, err, want)
+		}
+	}
+}
+
+func TestParseClusterJSON(t *testing.T) {
+	cluster, err := ParseClusterJSON([]byte(`{
+		"peers": [
+			{"id": 2, "name": "b", "host": "10.0.0.2", "port": 9000},
+			{"id": 1, "name": "a"}
+		],
+		"groups": [{"id": 1, "proposers": ["a"], "acceptors": ["a", "b"]}],
+		"prepare_timeout": "3s"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PeerConfig{
+		{Id: 1, Name: "a", Host: "a", Port: DefaultPort},
+		{Id: 2, Name: "b", Host: "10.0.0.2", Port: 9000},
+	}
+	if !reflect.DeepEqual(cluster.Peers, want) {
+		t.Errorf("peers = %+v, want %+v", cluster.Peers, want)
+	}
+	if cluster.PrepareTimeout != Duration(3*time.Second) {
+		t.Errorf("prepare timeout = %v, want 3s", cluster.PrepareTimeout)
+	}
+
+	for _, content := range []string{
+		`{"peers": [], "unknown": 1}`,
+		`{"peers": [], "prepare_timeout": 3}`,
+		`{"peers": [], "prepare_timeout": "soon"}`,
+	} {
+		if _, err := ParseClusterJSON([]byte(content)); err == nil {
+			t.Errorf("ParseClusterJSON(%s) succeeded, want an error", content)
+		}
+	}
+}
+
+func TestProblems(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		hosts    string
+		problems []string
+	}{
+		{"valid", "p:proposer1\na1:acceptor1\na2:acceptor1\na3:acceptor1\nl:learner1", nil},
+		{"shared acceptors", "p:proposer1\nq:proposer2\na:acceptor1,acceptor2", nil},
+		{"competing proposers", "p:proposer1.1\nq:proposer1.2\na:acceptor1", nil},
+		{"duplicate peer", "p:proposer1\np:acceptor1", []string{"duplicate peer: p"}},
+		{"shared address", "p@host:proposer1\na@host:acceptor1", []string{"peers p and a share the address host:8080"}},
+		{"invalid port", "p@host:70000:proposer1\na:acceptor1", []string{"invalid port 70000 for peer p"}},
+		{"no acceptors", "p:proposer1", []string{"group 1 has proposers [p] but no acceptors"}},
+		{"learner without proposer", "p:proposer1\na:acceptor1,acceptor2\nl:learner2", []string{"learners [l] belong to group 2, which has no proposers"}},
+		{"shared identity", "p:proposer1.1\nq:proposer1.1\na:acceptor1", []string{"proposers p and q of group 1 share the identity 1"}},
+		{"proposer in two groups", "p:proposer1,proposer2\na:acceptor1,acceptor2", []string{"peer p proposes for groups [1 2], but a peer can propose for only one group"}},
+	} {
+		cluster, err := ParseHostsFile([]byte(test.hosts))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var problems []string
+		for _, problem := range cluster.Problems(0, 0) {
+			problems = append(problems, problem.Error())
+		}
+		if !reflect.DeepEqual(problems, test.problems) {
+			t.Errorf("%s: Problems() = %q, want %q", test.name, problems, test.problems)
+		}
+	}
+}
+
+func TestProblemsChecksQuorums(t *testing.T) {
+	cluster, err := ParseHostsFile([]byte("p:proposer1\na1:acceptor1\na2:acceptor1\na3:acceptor1\na4:acceptor1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := cluster.Problems(3, 2); len(problems) != 0 {
+		t.Errorf("Problems(3, 2) with 4 acceptors = %v, want none", problems)
+	}
+	if problems := cluster.Problems(2, 2); len(problems) != 1 {
+		t.Errorf("Problems(2, 2) with 4 acceptors = %v, want one quorum problem", problems)
+	}
+	cluster.Groups[0].AcceptQuorum = 3
+	if problems := cluster.Problems(2, 2); len(problems) != 0 {
+		t.Errorf("Problems(2, 2) with a group accept quorum of 3 = %v, want none", problems)
+	}
+}
+
+func TestProblemsInJSONConfig(t *testing.T) {
+	cluster, err := ParseClusterJSON([]byte(`{
+		"peers": [{"id": 1, "name": "p"}, {"id": 3, "name": "a"}],
+		"groups": [
+			{"id": 1, "proposers": ["p"], "acceptors": ["a", "ghost"], "proposer_ids": {"a": 2}},
+			{"id": 1, "acceptors": ["a"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"peer IDs must be unique and run from 1 to 2, found 3 for a",
+		"group 1 refers to unknown peer: ghost",
+		"group 1 gives an identity to a, which is not one of its proposers",
+		"duplicate group: 1",
+	}
+	var problems []string
+	for _, problem := range cluster.Problems(0, 0) {
+		problems = append(problems, problem.Error())
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("Problems() = %q, want %q", problems, want)
+	}
+}
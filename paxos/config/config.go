@@ -2,32 +2,101 @@ package config
 
 import (
 	"flag"
+	"strings"
+	"time"
 )
 
+type valuesFlag [][]byte
+
+func (vf *valuesFlag) String() string {
+	values := make([]string, len(*vf))
+	for i, value := range *vf {
+		values[i] = string(value)
+	}
+	return strings.Join(values, ",")
+}
+
+func (vf *valuesFlag) Set(value string) error {
+	*vf = append(*vf, []byte(value))
+	return nil
+}
+
 type Config struct {
-	HostsFile     string
-	ProposalValue rune
-	ProposalDelay int
+	HostsFile         string
+	Name              string
+	Id                int
+	ProposalValues    [][]byte
+	ProposalDelay     int
+	PrepareTimeout    time.Duration
+	AcceptTimeout     time.Duration
+	BackoffMin        time.Duration
+	BackoffMax        time.Duration
+	PrepareQuorumSize int
+	AcceptQuorumSize  int
+	DataDir           string
+	Codec             string
+	Transport         string
+	ReconnectMin      time.Duration
+	ReconnectMax      time.Duration
+	SendQueueSize     int
+	DialTimeout       time.Duration
+	WriteTimeout      time.Duration
+	TLSCert           string
+	TLSKey            string
+	TLSCA             string
+	ClusterKeyFile    string
 }
 
 func ParseFlags() *Config {
 	cfg := &Config{}
 
-	flag.StringVar(&cfg.HostsFile, "h", "", "Path to the hosts file")
-	var proposalValue string
-	flag.StringVar(&proposalValue, "v", "", "This is the value used if the peer is a proposer")
+	flag.StringVar(&cfg.HostsFile, "h", "", "Path to the hosts file or JSON cluster config")
+	flag.StringVar(&cfg.Name, "name", "", "Name of this peer's hosts file entry; defaults to the machine's hostname")
+	flag.IntVar(&cfg.Id, "id", 0, "1-based position of this peer's hosts file entry, as an alternative to -name")
+	flag.Var((*valuesFlag)(&cfg.ProposalValues), "v", "Value appended to the log if the peer is a proposer; repeat to append several values in order")
 	flag.IntVar(&cfg.ProposalDelay, "t", 0, "This is the time in seconds the peer will wait before starting its proposal with its value v")
+	flag.DurationVar(&cfg.PrepareTimeout, "prepare-timeout", 2*time.Second, "Time a proposer waits for a prepare quorum before retrying with a higher round")
+	flag.DurationVar(&cfg.AcceptTimeout, "accept-timeout", 2*time.Second, "Time a proposer waits for an accept quorum before retrying with a higher round")
+	flag.DurationVar(&cfg.BackoffMin, "backoff-min", 100*time.Millisecond, "Lower bound of the randomized backoff between proposal retries")
+	flag.DurationVar(&cfg.BackoffMax, "backoff-max", 5*time.Second, "Upper bound of the randomized backoff between proposal retries")
+	flag.IntVar(&cfg.PrepareQuorumSize, "prepare-quorum", 0, "Number of acceptors that must promise in phase 1; 0 uses a strict majority of the acceptor group")
+	flag.IntVar(&cfg.AcceptQuorumSize, "accept-quorum", 0, "Number of acceptors that must accept in phase 2; 0 uses a strict majority of the acceptor group")
+	flag.StringVar(&cfg.DataDir, "data-dir", "", "Directory for the acceptor's write-ahead log; acceptor state is kept only in memory if empty")
+	flag.StringVar(&cfg.Codec, "codec", "binary", "Wire format for peer messages: binary, or json for debugging")
+	flag.StringVar(&cfg.Transport, "transport", "tcp", "Transport between peers: tcp, or udp to send each message as a single datagram")
+	flag.DurationVar(&cfg.ReconnectMin, "reconnect-min", 100*time.Millisecond, "Lower bound of the randomized backoff between attempts to reconnect to an unreachable peer")
+	flag.DurationVar(&cfg.ReconnectMax, "reconnect-max", 5*time.Second, "Upper bound of the randomized backoff between attempts to reconnect to an unreachable peer")
+	flag.IntVar(&cfg.SendQueueSize, "send-queue", 0, "Messages queued per peer while it is unreachable, oldest dropped first; 0 drops them immediately")
+	flag.DurationVar(&cfg.DialTimeout, "dial-timeout", 3*time.Second, "Time allowed to connect to a peer")
+	flag.DurationVar(&cfg.WriteTimeout, "write-timeout", 5*time.Second, "Time allowed to write a message to a peer before its connection is evicted")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", "", "Path to this peer's PEM certificate, issued for its hosts file name; enables mutual TLS together with -tls-key and -tls-ca")
+	flag.StringVar(&cfg.TLSKey, "tls-key", "", "Path to the PEM private key for -tls-cert")
+	flag.StringVar(&cfg.TLSCA, "tls-ca", "", "Path to the PEM CA certificate that signs every peer's certificate")
+	flag.StringVar(&cfg.ClusterKeyFile, "cluster-key", "", "Path to a file of hex-encoded cluster keys, one per line, used to authenticate every message with an HMAC; the first key signs and a second key is also accepted during rotation")
 
 	flag.Parse()
 
-	if len(proposalValue) > 0 {
-		cfg.ProposalValue = rune(proposalValue[0])
-	} else {
-		cfg.ProposalValue = 0
+	if cfg.HostsFile == "" {
+		flag.Usage()
+		return nil
 	}
 
+	return cfg
+}
+
+// ParseValidateFlags parses the arguments of the validate subcommand.
+func ParseValidateFlags(args []string) *Config {
+	cfg := &Config{}
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+
+	flags.StringVar(&cfg.HostsFile, "h", "", "Path to the hosts file or JSON cluster config to check")
+	flags.IntVar(&cfg.PrepareQuorumSize, "prepare-quorum", 0, "Phase-1 quorum size the peers will be started with; 0 uses a strict majority of each acceptor group")
+	flags.IntVar(&cfg.AcceptQuorumSize, "accept-quorum", 0, "Phase-2 quorum size the peers will be started with; 0 uses a strict majority of each acceptor group")
+
+	flags.Parse(args)
+
 	if cfg.HostsFile == "" {
-		flag.Usage()
+		flags.Usage()
 		return nil
 	}
 
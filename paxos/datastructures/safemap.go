@@ -0,0 +1,55 @@
+package datastructures
+
+import (
+	"sync"
+)
+
+type SafeMap[K comparable, V any] struct {
+	items map[K]V
+	lock  sync.Mutex
+}
+
+func (sm *SafeMap[K, V]) Length() int {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	return len(sm.items)
+}
+
+func (sm *SafeMap[K, V]) Get(key K) (V, bool) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	value, ok := sm.items[key]
+	return value, ok
+}
+
+func (sm *SafeMap[K, V]) Set(key K, value V) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.items[key] = value
+}
+
+// SetIfAbsent stores value under key unless the key is already present,
+// and returns the resulting number of entries.
+func (sm *SafeMap[K, V]) SetIfAbsent(key K, value V) (int, bool) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	if _, ok := sm.items[key]; ok {
+		return len(sm.items), false
+	}
+	sm.items[key] = value
+	return len(sm.items), true
+}
+
+func (sm *SafeMap[K, V]) GetAll() map[K]V {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	items := make(map[K]V, len(sm.items))
+	for key, value := range sm.items {
+		items[key] = value
+	}
+	return items
+}
+
+func NewSafeMap[K comparable, V any]() *SafeMap[K, V] {
+	return &SafeMap[K, V]{items: make(map[K]V)}
+}
@@ -1,6 +1,7 @@
 package datastructures
 
 import (
+	"encoding/json"
 	"sync"
 )
 
@@ -20,6 +21,27 @@ func (sv *SafeValue[T]) Set(value T) {
 	defer sv.lock.Unlock()
 	sv.value = value
 }
+
+func (sv *SafeValue[T]) Update(update func(T) T) T {
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
+	sv.value = update(sv.value)
+	return sv.value
+}
+
+func (sv *SafeValue[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sv.Get())
+}
+
+func (sv *SafeValue[T]) UnmarshalJSON(data []byte) error {
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	sv.Set(value)
+	return nil
+}
+
 func NewSafeValue[T any](initialValue T) *SafeValue[T] {
 	return &SafeValue[T]{value: initialValue}
 }
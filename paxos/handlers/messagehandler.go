@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
-	"net"
+	"slices"
+	"sync"
+	"time"
 
 	"paxos/paxos/datastructures"
 	"paxos/paxos/network"
@@ -10,8 +13,13 @@ import (
 	"paxos/paxos/utils"
 )
 
+// DrainTimeout bounds how long HandleMessages waits for in-flight messages
+// once it is cancelled.
+const DrainTimeout = 5 * time.Second
+
 type MessageHandler struct {
-	Peer *network.Peer
+	Peer     *network.Peer
+	inFlight sync.WaitGroup
 }
 
 func NewMessageHandler(p *network.Peer) *MessageHandler {
@@ -20,194 +28,403 @@ func NewMessageHandler(p *network.Peer) *MessageHandler {
 	}
 }
 
-func (mh *MessageHandler) HandleMessages() {
+// HandleMessages dispatches inbound and outbound messages until ctx is
+// cancelled. It then stops the peer and returns once the messages already
+// being handled, and the replies they produce, are sent, or after
+// DrainTimeout.
+func (mh *MessageHandler) HandleMessages(ctx context.Context) {
 	for {
 		select {
-		case inboundMessage := <-mh.Peer.ReadChannel:
-			go mh.processInboundMessage(inboundMessage)
+		case inboundMessage := <-mh.Peer.Transport.Receive():
+			mh.inFlight.Add(1)
+			go func() {
+				defer mh.inFlight.Done()
+				mh.processInboundMessage(inboundMessage)
+			}()
+		case outboundMessage := <-mh.Peer.WriteChannel:
+			mh.inFlight.Add(1)
+			go func() {
+				defer mh.inFlight.Done()
+				mh.sendMessage(outboundMessage)
+			}()
+		case <-ctx.Done():
+			mh.Peer.Stop()
+			mh.drain()
+			return
+		}
+	}
+}
+
+// drain keeps sending what the peer queues until the messages in flight are
+// done, then waits for those sends, all within DrainTimeout. No new inbound
+// messages are taken.
+func (mh *MessageHandler) drain() {
+	timeout := time.After(DrainTimeout)
+	drained := make(chan struct{})
+	go func() {
+		mh.inFlight.Wait()
+		close(drained)
+	}()
+	// The sends get their own WaitGroup: a reply may come from a goroutine
+	// that is not in flight, and adding to inFlight while it is being waited
+	// on is not allowed.
+	var sending sync.WaitGroup
+	for handling := true; handling; {
+		select {
 		case outboundMessage := <-mh.Peer.WriteChannel:
-			go mh.sendMessage(outboundMessage)
+			sending.Add(1)
+			go func() {
+				defer sending.Done()
+				mh.sendMessage(outboundMessage)
+			}()
+		case <-drained:
+			handling = false
+		case <-timeout:
+			fmt.Println("Timed out waiting for in-flight messages, dropping them")
+			return
 		}
 	}
+	sent := make(chan struct{})
+	go func() {
+		sending.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-timeout:
+		fmt.Println("Timed out waiting for in-flight messages, dropping them")
+	}
+}
+
+// spawn runs f in its own goroutine, which the drain waits for. It must only
+// be called while handling a message, so that inFlight is not zero.
+func (mh *MessageHandler) spawn(f func()) {
+	mh.inFlight.Add(1)
+	go func() {
+		defer mh.inFlight.Done()
+		f()
+	}()
 }
 
 func (mh *MessageHandler) processInboundMessage(message types.InboundMessage) {
-	data, err := types.Deserialize(message.Data)
-	if err != nil {
-		fmt.Println("Error decoding byte array to integers:", err)
+	if _, err := utils.GetPeerNameFromId(message.SenderId, mh.Peer.Peers.GetAll()); err != nil {
+		fmt.Printf("Error identifying sender %v: %v\n", message.Sender, err)
 		return
 	}
 
-	sender, err := utils.GetHostnameFromAddr(message.Sender)
-	if err != nil {
-		fmt.Println("Error getting hostname from address:", err)
-		return
+	data := message.Data
+	if mh.Peer.Authenticator != nil {
+		var err error
+		data, err = mh.Peer.Authenticator.Verify(message.Type, message.SenderId, message.Data)
+		if err != nil {
+			rejected := mh.Peer.Unauthenticated.Update(func(count int) int { return count + 1 })
+			fmt.Printf("Rejected message claiming to be from peer %d (%d rejected so far): %v\n", message.SenderId, rejected, err)
+			return
+		}
 	}
 
-	sender = utils.CleanHostname(sender)
-	mh.handleMessage(types.MessageType(data[0]), data[1:], sender)
+	mh.handleMessage(message.Type, data, message.SenderId)
 }
 
-func (mh *MessageHandler) handleMessage(msgType types.MessageType, data []int, sender string) {
+func (mh *MessageHandler) handleMessage(msgType types.MessageType, data []byte, senderId int) {
 	switch msgType {
 	case types.PREPARE:
-		mh.handlePrepareMessage(data, sender)
+		mh.handlePrepareMessage(data, senderId)
 	case types.PREPARE_ACK:
-		mh.handlePrepareAckMessage(data, sender)
+		mh.handlePrepareAckMessage(data, senderId)
 	case types.ACCEPT:
-		mh.handleAcceptMessage(data, sender)
+		mh.handleAcceptMessage(data, senderId)
 	case types.ACCEPT_ACK:
-		mh.handleAcceptAckMessage(data, sender)
+		mh.handleAcceptAckMessage(data, senderId)
+	case types.LEARN:
+		mh.handleLearnMessage(data, senderId)
+	case types.PREPARE_NACK:
+		mh.handlePrepareNackMessage(data, senderId)
+	case types.ACCEPT_NACK:
+		mh.handleAcceptNackMessage(data, senderId)
+	default:
+		fmt.Println("Error handling message: unknown message type", msgType)
 	}
 }
 
-func (mh *MessageHandler) handlePrepareMessage(data []int, sender string) {
-	senderId, _ := utils.GetPeerIdFromName(sender, mh.Peer.Peers.GetAll())
+func (mh *MessageHandler) handlePrepareMessage(data []byte, senderId int) {
+	message, err := mh.Peer.Codec.DecodePrepare(data)
+	if err != nil {
+		fmt.Println("Error decoding prepare message:", err)
+		return
+	}
+	group := message.Group.Get()
+	slot := message.Slot.Get()
+	proposalNumber := message.ProposalNumber
 	mh.Peer.LogMessage(
 		"received",
 		"prepare",
-		rune(data[2]),
+		slot,
+		message.ProposalValue.Get(),
 		senderId,
 		fmt.Sprintf(
 			"%d.%d",
-			data[0],
-			data[1],
+			proposalNumber.RoundNumber.Get(),
+			proposalNumber.ServerId.Get(),
 		),
 	)
-	proposalNumber := &types.ProposalNumber{
-		RoundNumber: datastructures.NewSafeValue(data[0]),
-		ServerId:    datastructures.NewSafeValue(data[1]),
+	if !mh.Peer.AcceptsFor(group) {
+		fmt.Printf("Ignoring prepare for group %d, which this peer does not accept for\n", group)
+		return
 	}
 	n := utils.GetN(int32(proposalNumber.RoundNumber.Get()), int32(proposalNumber.ServerId.Get()))
-	if n > mh.Peer.Store.MinProposalNumber.Get() {
-		mh.Peer.Store.MinProposalNumber.Set(n)
+	_, promised, err := mh.Peer.Storage.Promise(group, n)
+	if err != nil {
+		fmt.Println("Error persisting promise:", err)
+		return
+	}
+	if !promised {
+		mh.spawn(func() { mh.Peer.SendPrepareNack(senderId, group, slot, n) })
+		return
 	}
-	if mh.Peer.Store.RoundNumber.Get() < proposalNumber.RoundNumber.Get() {
-		mh.Peer.Store.RoundNumber.Set(proposalNumber.RoundNumber.Get())
+	// A rival proposer in this peer's own group has taken over leadership.
+	if group == mh.Peer.Group {
+		if n > utils.GetN(int32(mh.Peer.RoundNumber.Get()), int32(mh.Peer.ProposerId)) {
+			mh.Peer.Prepared.Set(false)
+		}
+		mh.Peer.HighestRound.Update(func(round int) int { return max(round, proposalNumber.RoundNumber.Get()) })
 	}
-	go mh.Peer.SendPrepareAck(sender)
+	mh.spawn(func() { mh.Peer.SendPrepareAck(senderId, group, slot, n) })
 }
 
-func (mh *MessageHandler) handlePrepareAckMessage(data []int, sender string) {
-	senderId, _ := utils.GetPeerIdFromName(sender, mh.Peer.Peers.GetAll())
+func (mh *MessageHandler) handlePrepareAckMessage(data []byte, senderId int) {
+	message, err := mh.Peer.Codec.DecodePrepareAck(data)
+	if err != nil {
+		fmt.Println("Error decoding prepare_ack message:", err)
+		return
+	}
+	slot := message.Slot.Get()
 	mh.Peer.LogMessage(
 		"received",
 		"prepare_ack",
-		rune(data[2]),
+		slot,
+		message.AcceptedValue.Get(),
 		senderId,
 		fmt.Sprintf(
 			"%d.%d",
-			data[0],
-			data[1],
+			message.AcceptedProposalNumber.RoundNumber.Get(),
+			message.AcceptedProposalNumber.ServerId.Get(),
 		),
 	)
-	n := utils.GetN(int32(mh.Peer.Store.RoundNumber.Get()), int32(mh.Peer.Id))
-	if _, ok := mh.Peer.PrepareAck.Load(n); !ok {
-		mh.Peer.PrepareAck.Store(n, datastructures.NewSafeList(make([][]int, 0)))
-	}
-	var dataList *datastructures.SafeList[[]int]
-	value, _ := mh.Peer.PrepareAck.Load(n)
-	dataList = value.(*datastructures.SafeList[[]int])
-	dataList.Add(data)
-	if dataList.Length() == mh.Peer.QuorumSize.Get() {
-		highestProposalNumber := utils.GetN(-1, int32(mh.Peer.Id))
-		for _, data := range dataList.GetAll() {
-			acceptedRoundNumber := int32(data[0])
-			acceptedServerId := int32(data[1])
+	if slot != mh.Peer.Slot.Get() || !mh.fromAcceptor(mh.Peer.Acceptors.GetAll(), senderId) {
+		return
+	}
+	roundNumber := mh.Peer.RoundNumber.Get()
+	n := utils.GetN(int32(roundNumber), int32(mh.Peer.ProposerId))
+	if utils.GetN(int32(message.ProposalNumber.RoundNumber.Get()), int32(message.ProposalNumber.ServerId.Get())) != n {
+		return
+	}
+	key := types.InstanceKey{Slot: slot, N: n}
+	value, _ := mh.Peer.PrepareAck.LoadOrStore(key, datastructures.NewSafeMap[int, *types.PrepareAckMessage]())
+	acks := value.(*datastructures.SafeMap[int, *types.PrepareAckMessage])
+	if count, added := acks.SetIfAbsent(senderId, message); added && count == mh.Peer.PrepareQuorumSize.Get() {
+		command, ok := mh.Peer.Pending.Get(0)
+		if !ok {
+			return
+		}
+		proposal := command.Value
+		highestProposalNumber := utils.GetN(-1, int32(mh.Peer.ProposerId))
+		noMoreAccepted := true
+		for _, ack := range acks.GetAll() {
+			if !ack.NoMoreAccepted.Get() {
+				noMoreAccepted = false
+			}
+			acceptedRoundNumber := int32(ack.AcceptedProposalNumber.RoundNumber.Get())
+			acceptedServerId := int32(ack.AcceptedProposalNumber.ServerId.Get())
 			acceptedN := utils.GetN(acceptedRoundNumber, acceptedServerId)
-			acceptedValue := data[2]
-			if acceptedValue != 0 && acceptedN > highestProposalNumber {
+			if acceptedN != 0 && acceptedN > highestProposalNumber {
 				highestProposalNumber = acceptedN
-				mh.Peer.ProposalValue.Set(rune(acceptedValue))
+				proposal = ack.AcceptedValue.Get()
+				command = nil
 			}
 		}
-		go mh.Peer.SendAccept()
+		mh.Peer.ProposalValue.Set(proposal)
+		mh.Peer.Prepared.Set(noMoreAccepted)
+		mh.spawn(func() { mh.Peer.SendAccept(slot, roundNumber, proposal, command) })
 	}
 }
 
-func (mh *MessageHandler) handleAcceptMessage(data []int, sender string) {
-	senderId, _ := utils.GetPeerIdFromName(sender, mh.Peer.Peers.GetAll())
+func (mh *MessageHandler) handleAcceptMessage(data []byte, senderId int) {
+	message, err := mh.Peer.Codec.DecodeAccept(data)
+	if err != nil {
+		fmt.Println("Error decoding accept message:", err)
+		return
+	}
+	group := message.Group.Get()
+	slot := message.Slot.Get()
+	proposalNumber := message.ProposalNumber
 	mh.Peer.LogMessage(
 		"received",
 		"accept",
-		rune(data[2]),
+		slot,
+		message.ProposalValue.Get(),
 		senderId,
 		fmt.Sprintf(
 			"%d.%d",
-			data[0],
-			data[1],
+			proposalNumber.RoundNumber.Get(),
+			proposalNumber.ServerId.Get(),
 		),
 	)
-	proposalNumber := types.ProposalNumber{
-		RoundNumber: datastructures.NewSafeValue(data[0]),
-		ServerId:    datastructures.NewSafeValue(data[1]),
+	if !mh.Peer.AcceptsFor(group) {
+		fmt.Printf("Ignoring accept for group %d, which this peer does not accept for\n", group)
+		return
 	}
-	proposalValue := data[2]
 	n := utils.GetN(int32(proposalNumber.RoundNumber.Get()), int32(proposalNumber.ServerId.Get()))
-	if n >= mh.Peer.Store.MinProposalNumber.Get() {
-		mh.Peer.Store.MinProposalNumber.Set(n)
-		mh.Peer.Store.AcceptedProposalNumber.Set(n)
-		mh.Peer.Store.AcceptedValue.Set(rune(proposalValue))
+	promise, accepted, err := mh.Peer.Storage.Accept(group, slot, n, message.ProposalValue.Get())
+	if err != nil {
+		fmt.Println("Error persisting accepted proposal:", err)
+		return
+	}
+	if !accepted {
+		// A proposer sends one value per slot and ballot; a second one would
+		// overwrite a value that may already have been chosen.
+		if promise == n {
+			fmt.Printf("Refusing a second value for slot %d at proposal %d.%d\n", slot, proposalNumber.RoundNumber.Get(), proposalNumber.ServerId.Get())
+		}
+		mh.spawn(func() { mh.Peer.SendAcceptNack(senderId, group, slot, n) })
+		return
 	}
-	go mh.Peer.SendAcceptAck(sender)
+	mh.spawn(func() { mh.Peer.SendLearn(group, slot) })
+	mh.spawn(func() { mh.Peer.SendAcceptAck(senderId, group, slot, n) })
 }
 
-func (mh *MessageHandler) handleAcceptAckMessage(data []int, sender string) {
-	senderId, _ := utils.GetPeerIdFromName(sender, mh.Peer.Peers.GetAll())
+func (mh *MessageHandler) handleAcceptAckMessage(data []byte, senderId int) {
+	message, err := mh.Peer.Codec.DecodeAcceptAck(data)
+	if err != nil {
+		fmt.Println("Error decoding accept_ack message:", err)
+		return
+	}
+	slot := message.Slot.Get()
 	mh.Peer.LogMessage(
 		"received",
 		"accept_ack",
+		slot,
 		mh.Peer.ProposalValue.Get(),
 		senderId,
 		fmt.Sprintf(
 			"%d.%d",
-			data[0],
-			data[1],
+			message.ProposalNumber.RoundNumber.Get(),
+			message.ProposalNumber.ServerId.Get(),
 		),
 	)
-	n := utils.GetN(int32(mh.Peer.Store.RoundNumber.Get()), int32(mh.Peer.Id))
-	if _, ok := mh.Peer.AcceptAck.Load(n); !ok {
-		mh.Peer.AcceptAck.Store(n, datastructures.NewSafeList(make([][]int, 0)))
-	}
-	var dataList *datastructures.SafeList[[]int]
-	value, _ := mh.Peer.AcceptAck.Load(n)
-	dataList = value.(*datastructures.SafeList[[]int])
-	dataList.Add(data)
-	if dataList.Length() == mh.Peer.QuorumSize.Get() {
-		for _, data := range dataList.GetAll() {
-			minProposalRoundNumber := int32(data[0])
-			minProposalServerId := int32(data[1])
-			minProposalN := utils.GetN(minProposalRoundNumber, minProposalServerId)
-			if minProposalN < n {
-				go mh.Peer.SendPrepare()
-				return
-			}
-		}
-		mh.Peer.LogMessage(
-			"chose",
-			"chose",
-			mh.Peer.ProposalValue.Get(),
-			mh.Peer.Id,
-			fmt.Sprintf(
-				"%d.%d",
-				mh.Peer.Store.RoundNumber.Get(),
-				mh.Peer.Id,
-			),
-		)
+	if slot != mh.Peer.Slot.Get() || !mh.fromAcceptor(mh.Peer.Acceptors.GetAll(), senderId) {
+		return
+	}
+	n := utils.GetN(int32(mh.Peer.RoundNumber.Get()), int32(mh.Peer.ProposerId))
+	if utils.GetN(int32(message.ProposalNumber.RoundNumber.Get()), int32(message.ProposalNumber.ServerId.Get())) != n {
+		return
+	}
+	key := types.InstanceKey{Slot: slot, N: n}
+	value, _ := mh.Peer.AcceptAck.LoadOrStore(key, datastructures.NewSafeMap[int, *types.AcceptAckMessage]())
+	acks := value.(*datastructures.SafeMap[int, *types.AcceptAckMessage])
+	if count, added := acks.SetIfAbsent(senderId, message); added && count == mh.Peer.AcceptQuorumSize.Get() {
+		mh.Peer.Decide(slot, n)
 	}
 }
 
-func (mh *MessageHandler) sendMessage(outboundMessage types.OutboundMessage) {
-	conn, err := mh.Peer.TCPEgress.Get(outboundMessage.Recipient)
+func (mh *MessageHandler) handlePrepareNackMessage(data []byte, senderId int) {
+	message, err := mh.Peer.Codec.DecodePrepareNack(data)
+	if err != nil {
+		fmt.Println("Error decoding prepare_nack message:", err)
+		return
+	}
+	mh.handleNack("prepare_nack", message.Slot.Get(), message.ProposalNumber, message.PromisedProposalNumber, senderId)
+}
+
+func (mh *MessageHandler) handleAcceptNackMessage(data []byte, senderId int) {
+	message, err := mh.Peer.Codec.DecodeAcceptNack(data)
+	if err != nil {
+		fmt.Println("Error decoding accept_nack message:", err)
+		return
+	}
+	mh.handleNack("accept_nack", message.Slot.Get(), message.ProposalNumber, message.PromisedProposalNumber, senderId)
+}
+
+// handleNack abandons the current round as soon as one acceptor reports a
+// higher promise, and retries phase 1 above that promise.
+func (mh *MessageHandler) handleNack(messageType string, slot int, proposalNumber, promisedProposalNumber *types.ProposalNumber, senderId int) {
+	mh.Peer.LogMessage(
+		"received",
+		messageType,
+		slot,
+		mh.Peer.ProposalValue.Get(),
+		senderId,
+		fmt.Sprintf(
+			"%d.%d",
+			promisedProposalNumber.RoundNumber.Get(),
+			promisedProposalNumber.ServerId.Get(),
+		),
+	)
+	if slot != mh.Peer.Slot.Get() || !mh.fromAcceptor(mh.Peer.Acceptors.GetAll(), senderId) {
+		return
+	}
+	n := utils.GetN(int32(proposalNumber.RoundNumber.Get()), int32(proposalNumber.ServerId.Get()))
+	if n != utils.GetN(int32(mh.Peer.RoundNumber.Get()), int32(mh.Peer.ProposerId)) {
+		return
+	}
+	if _, loaded := mh.Peer.Rejected.LoadOrStore(types.InstanceKey{Slot: slot, N: n}, true); loaded {
+		return
+	}
+	mh.spawn(func() { mh.Peer.Retry(promisedProposalNumber.RoundNumber.Get()) })
+}
 
+func (mh *MessageHandler) handleLearnMessage(data []byte, senderId int) {
+	message, err := mh.Peer.Codec.DecodeLearn(data)
 	if err != nil {
-		fmt.Printf("Error getting connection: %v\n", err)
+		fmt.Println("Error decoding learn message:", err)
 		return
 	}
+	group := message.Group.Get()
+	slot := message.Slot.Get()
+	proposalNumber := fmt.Sprintf(
+		"%d.%d",
+		message.ProposalNumber.RoundNumber.Get(),
+		message.ProposalNumber.ServerId.Get(),
+	)
+	mh.Peer.LogMessage(
+		"received",
+		"learn",
+		slot,
+		message.AcceptedValue.Get(),
+		senderId,
+		proposalNumber,
+	)
+	quorumSize, ok := mh.Peer.LearnerGroups.Load(group)
+	if !ok {
+		return
+	}
+	if _, chosen := mh.Peer.Chosen(group, slot); chosen {
+		return
+	}
+	if acceptors, _ := mh.Peer.LearnerAcceptors.Load(group); !mh.fromAcceptor(acceptors.([]string), senderId) {
+		fmt.Printf("Ignoring learn from peer %d, which is not an acceptor of group %d\n", senderId, group)
+		return
+	}
+	n := utils.GetN(int32(message.ProposalNumber.RoundNumber.Get()), int32(message.ProposalNumber.ServerId.Get()))
+	key := types.InstanceKey{Group: group, Slot: slot, N: n}
+	value, _ := mh.Peer.Learned.LoadOrStore(key, datastructures.NewSafeMap[int, bool]())
+	acceptors := value.(*datastructures.SafeMap[int, bool])
+	if count, added := acceptors.SetIfAbsent(senderId, true); added && count == quorumSize.(int) {
+		mh.Peer.Learn(group, slot, message.AcceptedValue.Get(), proposalNumber)
+	}
+}
 
-	tcpConn := conn.(net.Conn)
-	_, err = tcpConn.Write(outboundMessage.Data)
+// fromAcceptor reports whether senderId is the ID of one of acceptors. Sender
+// IDs are only as trustworthy as the transport, so a peer outside the group
+// must not be able to make up a quorum or abandon a round.
+func (mh *MessageHandler) fromAcceptor(acceptors []string, senderId int) bool {
+	name, err := utils.GetPeerNameFromId(senderId, mh.Peer.Peers.GetAll())
+	return err == nil && slices.Contains(acceptors, name)
+}
+
+func (mh *MessageHandler) sendMessage(outboundMessage types.OutboundMessage) {
+	err := mh.Peer.Transport.Send(outboundMessage.RecipientId, outboundMessage.Type, outboundMessage.Data)
 	if err != nil {
-		fmt.Printf("Error sending message: %v\n", err)
+		fmt.Printf("Error sending message to peer %d: %v\n", outboundMessage.RecipientId, err)
 	}
 }
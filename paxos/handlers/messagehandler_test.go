@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"paxos/paxos/codec"
+	"paxos/paxos/config"
+	"paxos/paxos/datastructures"
+	"paxos/paxos/network"
+	"paxos/paxos/storage"
+	"paxos/paxos/types"
+	"paxos/paxos/utils"
+)
+
+// hosts has five acceptors and a learner, so both quorums are three, and
+// two proposers, one of which is also an acceptor.
+const hosts = `peer1:proposer1
+peer2:acceptor1
+peer3:acceptor1
+peer4:acceptor1
+peer5:acceptor1
+peer6:acceptor1,proposer1
+peer7:learner1
+`
+
+func newTestHandler(t *testing.T, name string, store storage.Storage) *MessageHandler {
+	t.Helper()
+	return newTestHandlerOn(t, network.NewMemoryNetwork(), name, store)
+}
+
+// newTestHandlerOn is newTestHandler with the peer attached to memoryNetwork.
+func newTestHandlerOn(t *testing.T, memoryNetwork *network.MemoryNetwork, name string, store storage.Storage) *MessageHandler {
+	t.Helper()
+	cluster, err := config.ParseHostsFile([]byte(hosts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Codec:          "binary",
+		PrepareTimeout: time.Hour,
+		AcceptTimeout:  time.Hour,
+		BackoffMin:     time.Millisecond,
+		BackoffMax:     time.Millisecond,
+	}
+	peer, err := network.NewPeerWithTransport(cfg, cluster, name, store, memoryNetwork.NewTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return NewMessageHandler(peer)
+}
+
+// sent collects what the peer sends until it has been quiet for a while.
+func sent(peer *network.Peer) []types.OutboundMessage {
+	var messages []types.OutboundMessage
+	for {
+		select {
+		case message := <-peer.WriteChannel:
+			messages = append(messages, message)
+		case <-time.After(100 * time.Millisecond):
+			return messages
+		}
+	}
+}
+
+// sendAccept has the proposer send value for slot 1 at roundNumber, as it
+// would after a prepare quorum, and discards the accept messages. The value
+// counts as the first pending command's if their bytes are equal.
+func sendAccept(peer *network.Peer, roundNumber int, value []byte) {
+	peer.RoundNumber.Set(roundNumber)
+	command, _ := peer.Pending.Get(0)
+	if command != nil && !bytes.Equal(command.Value, value) {
+		command = nil
+	}
+	go peer.SendAccept(1, roundNumber, value, command)
+	sent(peer)
+}
+
+// count returns how many of messages are of messageType.
+func count(messages []types.OutboundMessage, messageType types.MessageType) int {
+	n := 0
+	for _, message := range messages {
+		if message.Type == messageType {
+			n++
+		}
+	}
+	return n
+}
+
+func proposalNumber(round, server int) *types.ProposalNumber {
+	return &types.ProposalNumber{
+		RoundNumber: datastructures.NewSafeValue(round),
+		ServerId:    datastructures.NewSafeValue(server),
+	}
+}
+
+func acceptAck(t *testing.T, slot, round, server int) []byte {
+	t.Helper()
+	data, err := codec.BinaryCodec{}.EncodeAcceptAck(&types.AcceptAckMessage{
+		Slot:           datastructures.NewSafeValue(slot),
+		ProposalNumber: proposalNumber(round, server),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func prepareAck(t *testing.T, slot, round, server int, accepted *types.ProposalNumber, value []byte) []byte {
+	t.Helper()
+	data, err := codec.BinaryCodec{}.EncodePrepareAck(&types.PrepareAckMessage{
+		Slot:                   datastructures.NewSafeValue(slot),
+		ProposalNumber:         proposalNumber(round, server),
+		AcceptedProposalNumber: accepted,
+		AcceptedValue:          datastructures.NewSafeValue(value),
+		NoMoreAccepted:         datastructures.NewSafeValue(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func acceptNack(t *testing.T, slot, round, server, promisedRound, promisedServer int) []byte {
+	t.Helper()
+	data, err := codec.BinaryCodec{}.EncodeAcceptNack(&types.AcceptNackMessage{
+		Slot:                   datastructures.NewSafeValue(slot),
+		ProposalNumber:         proposalNumber(round, server),
+		PromisedProposalNumber: proposalNumber(promisedRound, promisedServer),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func prepare(t *testing.T, slot, round, server int) []byte {
+	t.Helper()
+	data, err := codec.BinaryCodec{}.EncodePrepare(&types.PrepareMessage{
+		Group:          datastructures.NewSafeValue(1),
+		Slot:           datastructures.NewSafeValue(slot),
+		ProposalNumber: proposalNumber(round, server),
+		ProposalValue:  datastructures.NewSafeValue([]byte("a")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func accept(t *testing.T, slot, round, server int, value []byte) []byte {
+	t.Helper()
+	data, err := codec.BinaryCodec{}.EncodeAccept(&types.AcceptMessage{
+		Group:          datastructures.NewSafeValue(1),
+		Slot:           datastructures.NewSafeValue(slot),
+		ProposalNumber: proposalNumber(round, server),
+		ProposalValue:  datastructures.NewSafeValue(value),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func learn(t *testing.T, slot, round, server int, value []byte) []byte {
+	t.Helper()
+	data, err := codec.BinaryCodec{}.EncodeLearn(&types.LearnMessage{
+		Group:          datastructures.NewSafeValue(1),
+		Slot:           datastructures.NewSafeValue(slot),
+		ProposalNumber: proposalNumber(round, server),
+		AcceptedValue:  datastructures.NewSafeValue(value),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDelayedAcceptAckIsNotCounted(t *testing.T) {
+	mh := newTestHandler(t, "peer1", storage.NewMemoryStorage())
+	peer := mh.Peer
+	index := peer.Append([]byte("v"))
+	sendAccept(peer, 2, []byte("v"))
+
+	// An ack for the abandoned round 1 arrives after the proposer moved on.
+	mh.handleMessage(types.ACCEPT_ACK, acceptAck(t, 1, 1, 1), 2)
+	mh.handleMessage(types.ACCEPT_ACK, acceptAck(t, 1, 2, 1), 3)
+	mh.handleMessage(types.ACCEPT_ACK, acceptAck(t, 1, 2, 1), 4)
+	if _, ok := peer.Chosen(1, 1); ok {
+		t.Fatal("decided slot 1 with two acks for the current round and one for an earlier one")
+	}
+
+	mh.handleMessage(types.ACCEPT_ACK, acceptAck(t, 1, 2, 1), 5)
+	if value, ok := peer.Chosen(1, 1); !ok || string(value) != "v" {
+		t.Fatalf("Chosen(1, 1) = %q, %v after a quorum of acks; want \"v\", true", value, ok)
+	}
+	if slot := peer.Slot.Get(); slot != 2 {
+		t.Errorf("proposer is at slot %d after deciding slot 1, want 2", slot)
+	}
+	select {
+	case slot := <-index:
+		if slot != 1 {
+			t.Errorf("command was chosen at slot %d, want 1", slot)
+		}
+	case <-time.After(time.Second):
+		t.Error("proposer did not report the slot its command was chosen at")
+	}
+}
+
+func TestDecideRecordsTheValueSentInTheAccept(t *testing.T) {
+	mh := newTestHandler(t, "peer1", storage.NewMemoryStorage())
+	peer := mh.Peer
+	index := peer.Append([]byte("mine"))
+	sendAccept(peer, 1, []byte("adopted"))
+
+	// The proposal is reset to the pending command, as a retry would, before
+	// the acks for the adopted value arrive.
+	peer.ProposalValue.Set([]byte("mine"))
+	for _, acceptor := range []int{2, 3, 4} {
+		mh.handleMessage(types.ACCEPT_ACK, acceptAck(t, 1, 1, 1), acceptor)
+	}
+	if value, ok := peer.Chosen(1, 1); !ok || string(value) != "adopted" {
+		t.Fatalf("Chosen(1, 1) = %q, %v; want the adopted value the acceptors accepted", value, ok)
+	}
+	if peer.Pending.Length() != 1 {
+		t.Error("the pending command was removed although another value was chosen")
+	}
+	select {
+	case slot := <-index:
+		t.Errorf("the pending command was reported chosen at slot %d", slot)
+	default:
+	}
+}
+
+func TestDelayedPrepareAckIsNotAdopted(t *testing.T) {
+	mh := newTestHandler(t, "peer1", storage.NewMemoryStorage())
+	peer := mh.Peer
+	peer.Append([]byte("mine"))
+	peer.ProposalValue.Set([]byte("mine"))
+	peer.RoundNumber.Set(2)
+
+	// The stale ack reports a value accepted at 1.9, which would be adopted
+	// if the ack were counted towards round 2.
+	mh.handleMessage(types.PREPARE_ACK, prepareAck(t, 1, 1, 1, proposalNumber(1, 9), []byte("stale")), 2)
+	mh.handleMessage(types.PREPARE_ACK, prepareAck(t, 1, 2, 1, proposalNumber(0, 0), nil), 3)
+	mh.handleMessage(types.PREPARE_ACK, prepareAck(t, 1, 2, 1, proposalNumber(0, 0), nil), 4)
+	if messages := sent(peer); len(messages) != 0 {
+		t.Fatalf("sent %d messages with two prepare acks for the current round, want none", len(messages))
+	}
+
+	mh.handleMessage(types.PREPARE_ACK, prepareAck(t, 1, 2, 1, proposalNumber(0, 0), nil), 5)
+	messages := sent(peer)
+	if len(messages) != 5 {
+		t.Fatalf("sent %d messages after a prepare quorum, want an accept to each of 5 acceptors", len(messages))
+	}
+	for _, message := range messages {
+		if message.Type != types.ACCEPT {
+			t.Fatalf("sent %v after a prepare quorum, want accept", message.Type)
+		}
+		decoded, err := codec.BinaryCodec{}.DecodeAccept(message.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value := decoded.ProposalValue.Get(); string(value) != "mine" {
+			t.Errorf("accept carries %q, want the proposer's own value", value)
+		}
+	}
+}
+
+func TestRivalPrepareDoesNotMoveTheRound(t *testing.T) {
+	mh := newTestHandler(t, "peer6", storage.NewMemoryStorage())
+	peer := mh.Peer
+	peer.Append([]byte("mine"))
+	peer.RoundNumber.Set(1)
+	mh.handleMessage(types.PREPARE_ACK, prepareAck(t, 1, 1, 6, proposalNumber(0, 0), nil), 2)
+	mh.handleMessage(types.PREPARE_ACK, prepareAck(t, 1, 1, 6, proposalNumber(0, 0), nil), 3)
+
+	// peer1 prepares round 4 of the same group while peer6 waits for its
+	// prepare quorum; as an acceptor, peer6 promises it.
+	mh.handleMessage(types.PREPARE, prepare(t, 1, 4, 1), 1)
+	if messages := sent(peer); len(messages) != 1 || messages[0].Type != types.PREPARE_ACK {
+		t.Fatalf("sent %v for the rival's prepare, want one prepare_ack", messages)
+	}
+	if round := peer.RoundNumber.Get(); round != 1 {
+		t.Fatalf("proposer is at round %d after a rival's prepare, want it to stay at 1", round)
+	}
+
+	mh.handleMessage(types.PREPARE_ACK, prepareAck(t, 1, 1, 6, proposalNumber(0, 0), nil), 4)
+	messages := sent(peer)
+	if count(messages, types.ACCEPT) != 5 {
+		t.Fatalf("sent %v after a prepare quorum, want an accept to each of 5 acceptors", messages)
+	}
+	decoded, err := codec.BinaryCodec{}.DecodeAccept(messages[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if round, server := decoded.ProposalNumber.RoundNumber.Get(), decoded.ProposalNumber.ServerId.Get(); round != 1 || server != 6 {
+		t.Errorf("accept carries proposal %d.%d, want the prepared 1.6", round, server)
+	}
+
+	// The next prepare goes above the rival's round.
+	go peer.SendPrepare()
+	messages = sent(peer)
+	if count(messages, types.PREPARE) != 5 {
+		t.Fatalf("sent %v, want a prepare to each of 5 acceptors", messages)
+	}
+	decodedPrepare, err := codec.BinaryCodec{}.DecodePrepare(messages[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if round := decodedPrepare.ProposalNumber.RoundNumber.Get(); round != 5 {
+		t.Errorf("prepare carries round %d after a rival's round 4, want 5", round)
+	}
+}
+
+func TestAcceptorRefusesSecondValueForBallot(t *testing.T) {
+	mh := newTestHandler(t, "peer2", storage.NewMemoryStorage())
+	mh.handleMessage(types.ACCEPT, accept(t, 1, 1, 1, []byte("a")), 1)
+	if messages := sent(mh.Peer); len(messages) != 2 || count(messages, types.ACCEPT_ACK) != 1 || count(messages, types.LEARN) != 1 {
+		t.Fatalf("sent %v for the first value, want an accept_ack and a learn", messages)
+	}
+	mh.handleMessage(types.ACCEPT, accept(t, 1, 1, 1, []byte("b")), 1)
+	if messages := sent(mh.Peer); len(messages) != 1 || messages[0].Type != types.ACCEPT_NACK {
+		t.Fatalf("sent %v for a second value at the same ballot, want one accept_nack", messages)
+	}
+	if _, value := mh.Peer.Storage.GetAccepted(1, 1); string(value) != "a" {
+		t.Errorf("acceptor holds %q for slot 1, want the first value", value)
+	}
+}
+
+// faultyStorage fails every write, as a full or broken disk would.
+type faultyStorage struct {
+	*storage.MemoryStorage
+}
+
+func (faultyStorage) Promise(group int, n int64) (int64, bool, error) {
+	return 0, false, errors.New("disk full")
+}
+
+func (faultyStorage) Accept(group int, slot int, n int64, value []byte) (int64, bool, error) {
+	return 0, false, errors.New("disk full")
+}
+
+func TestAcceptorDoesNotAckUnpersistedState(t *testing.T) {
+	mh := newTestHandler(t, "peer2", faultyStorage{storage.NewMemoryStorage()})
+	mh.handleMessage(types.PREPARE, prepare(t, 1, 1, 1), 1)
+	mh.handleMessage(types.ACCEPT, accept(t, 1, 1, 1, []byte("a")), 1)
+	if messages := sent(mh.Peer); len(messages) != 0 {
+		t.Errorf("sent %v although storage failed, want nothing", messages)
+	}
+}
+
+func TestDecidedSlotIsForgotten(t *testing.T) {
+	mh := newTestHandler(t, "peer1", storage.NewMemoryStorage())
+	peer := mh.Peer
+	peer.Append([]byte("v"))
+	sendAccept(peer, 1, []byte("v"))
+	mh.handleMessage(types.ACCEPT_NACK, acceptNack(t, 1, 1, 1, 0, 0), 6)
+	for _, acceptor := range []int{2, 3, 4} {
+		mh.handleMessage(types.ACCEPT_ACK, acceptAck(t, 1, 1, 1), acceptor)
+	}
+	if _, ok := peer.Chosen(1, 1); !ok {
+		t.Fatal("slot 1 was not decided")
+	}
+	mh.handleMessage(types.ACCEPT_ACK, acceptAck(t, 1, 1, 1), 5)
+	if n := utils.Length(&peer.AcceptAck) + utils.Length(&peer.Rejected); n != 0 {
+		t.Errorf("proposer still tracks %d instances after deciding slot 1", n)
+	}
+
+	mh = newTestHandler(t, "peer7", storage.NewMemoryStorage())
+	for _, acceptor := range []int{2, 3, 4, 5} {
+		mh.handleMessage(types.LEARN, learn(t, 1, 1, 1, []byte("v")), acceptor)
+	}
+	if value, ok := mh.Peer.Chosen(1, 1); !ok || string(value) != "v" {
+		t.Fatalf("Chosen(1, 1) = %q, %v on the learner; want \"v\", true", value, ok)
+	}
+	if n := utils.Length(&mh.Peer.Learned); n != 0 {
+		t.Errorf("learner still tracks %d instances after learning slot 1", n)
+	}
+}
+
+func TestLearnerCountsOnlyAcceptors(t *testing.T) {
+	mh := newTestHandler(t, "peer7", storage.NewMemoryStorage())
+	for _, sender := range []int{1, 2, 3, 8} {
+		mh.handleMessage(types.LEARN, learn(t, 1, 1, 1, []byte("v")), sender)
+	}
+	if value, ok := mh.Peer.Chosen(1, 1); ok {
+		t.Fatalf("learned %q from the proposer, an unknown peer and only two acceptors", value)
+	}
+	mh.handleMessage(types.LEARN, learn(t, 1, 1, 1, []byte("v")), 4)
+	if _, ok := mh.Peer.Chosen(1, 1); !ok {
+		t.Error("did not learn from three acceptors")
+	}
+}
+
+func TestProposerCountsOnlyItsAcceptors(t *testing.T) {
+	mh := newTestHandler(t, "peer1", storage.NewMemoryStorage())
+	peer := mh.Peer
+	peer.Append([]byte("v"))
+	sendAccept(peer, 1, []byte("v"))
+
+	for _, sender := range []int{1, 7, 8, 2, 3} {
+		mh.handleMessage(types.ACCEPT_ACK, acceptAck(t, 1, 1, 1), sender)
+		mh.handleMessage(types.PREPARE_ACK, prepareAck(t, 1, 1, 1, proposalNumber(0, 0), nil), sender)
+	}
+	for _, sender := range []int{1, 7, 8} {
+		mh.handleMessage(types.ACCEPT_NACK, acceptNack(t, 1, 1, 1, 5, 9), sender)
+	}
+	if value, ok := peer.Chosen(1, 1); ok {
+		t.Fatalf("decided %q with acks from two acceptors, the proposer itself, the learner and an unknown peer", value)
+	}
+	if messages := sent(peer); len(messages) != 0 {
+		t.Fatalf("sent %v without a prepare quorum of acceptors", messages)
+	}
+	if n := utils.Length(&peer.Rejected); n != 0 {
+		t.Errorf("abandoned the round on nacks from peers that are not its acceptors")
+	}
+}
+
+// slowStorage holds up every promise until release is closed, and closes
+// promising when the first one starts.
+type slowStorage struct {
+	*storage.MemoryStorage
+	promising chan struct{}
+	release   chan struct{}
+	once      sync.Once
+}
+
+func (s *slowStorage) Promise(group int, n int64) (int64, bool, error) {
+	s.once.Do(func() { close(s.promising) })
+	<-s.release
+	return s.MemoryStorage.Promise(group, n)
+}
+
+func TestShutdownSendsRepliesToReceivedMessages(t *testing.T) {
+	memoryNetwork := network.NewMemoryNetwork()
+	store := &slowStorage{
+		MemoryStorage: storage.NewMemoryStorage(),
+		promising:     make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+	mh := newTestHandlerOn(t, memoryNetwork, "peer2", store)
+	proposer, err := memoryNetwork.NewTransport(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proposer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mh.HandleMessages(ctx)
+	}()
+	if err := proposer.Send(2, types.PREPARE, prepare(t, 1, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The acceptor is still persisting the promise when it is told to stop.
+	<-store.promising
+	cancel()
+	for !mh.Peer.Stopped() {
+		time.Sleep(time.Millisecond)
+	}
+	close(store.release)
+	select {
+	case message := <-proposer.Receive():
+		if message.Type != types.PREPARE_ACK {
+			t.Errorf("acceptor answered with %v, want prepare_ack", message.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acceptor did not answer a prepare it received before stopping")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("HandleMessages did not return once the reply was sent")
+	}
+}
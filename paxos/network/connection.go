@@ -2,8 +2,13 @@ package network
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"sync"
+	"time"
+
+	"paxos/paxos/datastructures"
+	"paxos/paxos/utils"
 )
 
 type ConnectionType int
@@ -13,26 +18,60 @@ const (
 	Outgoing
 )
 
+// Timeouts used by pools whose policy leaves them unset.
+const (
+	DefaultDialTimeout  = 3 * time.Second
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+// ReconnectPolicy controls how an outgoing pool treats unreachable peers.
+type ReconnectPolicy struct {
+	BackoffMin   time.Duration
+	BackoffMax   time.Duration
+	QueueSize    int           // messages held per peer while it is unreachable; 0 drops them
+	DialTimeout  time.Duration // time allowed to connect
+	WriteTimeout time.Duration // time allowed to write one message before the connection is evicted
+}
+
 type ConnectionPool struct {
-	Connections      sync.Map
-	Port             int
-	ConnectionType   ConnectionType
-	GetNewConnection func(net.Addr, int) (interface{}, error)
+	Connections       sync.Map // map[string]interface{}, keyed by address
+	ConnectionType    ConnectionType
+	GetNewConnection  func(net.Addr) (interface{}, error)
+	Policy            ReconnectPolicy
+	ReconnectAttempts *datastructures.SafeValue[int]
+	Evictions         *datastructures.SafeValue[int]
+	Dropped           *datastructures.SafeValue[int]
+	endpoints         sync.Map // map[string]*endpoint, keyed by address
+	closed            *datastructures.SafeValue[bool]
+}
+
+// endpoint is the outgoing state for one address. Its lock serializes
+// writes, so frames from concurrent senders never interleave.
+type endpoint struct {
+	mu           sync.Mutex
+	addr         net.Addr
+	conn         interface{}
+	connected    bool
+	failures     int
+	nextAttempt  time.Time
+	queue        [][]byte
+	reconnecting bool
+	reconnects   int
 }
 
 func (cp *ConnectionPool) Add(addr net.Addr, conn interface{}) {
-	cp.Connections.Store(addr, conn)
+	cp.Connections.Store(addr.String(), conn)
 }
 
 func (cp *ConnectionPool) Remove(addr net.Addr) {
-	cp.Connections.Delete(addr)
+	cp.Connections.Delete(addr.String())
 }
 
 func (cp *ConnectionPool) Get(addr net.Addr) (interface{}, error) {
-	conn, exists := cp.Connections.Load(addr)
+	conn, exists := cp.Connections.Load(addr.String())
 	if !exists && cp.ConnectionType == Outgoing {
 		var err error
-		conn, err = cp.GetNewConnection(addr, cp.Port)
+		conn, err = cp.GetNewConnection(addr)
 		if err != nil {
 			return nil, err
 		}
@@ -41,36 +80,238 @@ func (cp *ConnectionPool) Get(addr net.Addr) (interface{}, error) {
 	return conn, nil
 }
 
-func NewTCPConnectionPool(Port int, ConnectionType ConnectionType) *ConnectionPool {
-	return &ConnectionPool{
-		Port:           Port,
-		ConnectionType: ConnectionType,
-		GetNewConnection: func(addr net.Addr, Port int) (interface{}, error) {
-			return GetTCPConnection(addr, Port)
-		},
+// Send writes data to addr over the pooled connection. A connection that
+// fails is evicted and redialled with exponential backoff; while the peer is
+// unreachable data is queued up to Policy.QueueSize, or dropped.
+func (cp *ConnectionPool) Send(addr net.Addr, data []byte) error {
+	value, _ := cp.endpoints.LoadOrStore(addr.String(), &endpoint{addr: addr})
+	ep := value.(*endpoint)
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if len(ep.queue) > 0 {
+		return cp.enqueue(ep, data, fmt.Errorf("%d earlier messages are still queued", len(ep.queue)))
+	}
+	if ep.conn == nil {
+		if err := cp.connect(ep); err != nil {
+			return cp.enqueue(ep, data, err)
+		}
 	}
+	if err := cp.write(ep, data); err != nil {
+		// The connection may have gone stale while idle; redial once now.
+		if err := cp.connect(ep); err != nil {
+			return cp.enqueue(ep, data, err)
+		}
+		if err := cp.write(ep, data); err != nil {
+			return cp.enqueue(ep, data, err)
+		}
+	}
+	return nil
 }
 
-func NewUDPConnectionPool(Port int, ConnectionType ConnectionType) *ConnectionPool {
+// Reconnects returns the number of reconnect attempts made to addr.
+func (cp *ConnectionPool) Reconnects(addr net.Addr) int {
+	value, ok := cp.endpoints.Load(addr.String())
+	if !ok {
+		return 0
+	}
+	ep := value.(*endpoint)
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.reconnects
+}
+
+// Close closes every pooled connection and empties the pool. Connections
+// are closed before their endpoints are locked, so that a write blocked on
+// an unresponsive peer fails at once. Nothing is redialled afterwards.
+func (cp *ConnectionPool) Close() {
+	cp.closed.Set(true)
+	cp.Connections.Range(func(addr, conn any) bool {
+		if closer, ok := conn.(io.Closer); ok {
+			closer.Close()
+		}
+		cp.Connections.Delete(addr)
+		return true
+	})
+	cp.endpoints.Range(func(key, value any) bool {
+		ep := value.(*endpoint)
+		ep.mu.Lock()
+		if closer, ok := ep.conn.(io.Closer); ok {
+			closer.Close()
+		}
+		ep.conn = nil
+		ep.queue = nil
+		ep.mu.Unlock()
+		return true
+	})
+}
+
+// connect dials ep unless it is still backing off from an earlier failure.
+// The caller holds ep.mu.
+func (cp *ConnectionPool) connect(ep *endpoint) error {
+	if cp.closed.Get() {
+		return fmt.Errorf("connection pool is closed")
+	}
+	if wait := time.Until(ep.nextAttempt); wait > 0 {
+		return fmt.Errorf("peer %v is unreachable, next attempt in %v", ep.addr, wait.Round(time.Millisecond))
+	}
+	if ep.connected || ep.failures > 0 {
+		ep.reconnects++
+		cp.ReconnectAttempts.Update(func(attempts int) int { return attempts + 1 })
+	}
+	conn, err := cp.GetNewConnection(ep.addr)
+	if err != nil {
+		ep.failures++
+		ep.nextAttempt = time.Now().Add(utils.Backoff(cp.Policy.BackoffMin, cp.Policy.BackoffMax, ep.failures))
+		return err
+	}
+	ep.conn = conn
+	ep.connected = true
+	ep.failures = 0
+	ep.nextAttempt = time.Time{}
+	cp.Add(ep.addr, conn)
+	if netConn, ok := conn.(net.Conn); ok {
+		go cp.watch(ep, netConn)
+	}
+	return nil
+}
+
+// write sends data on the current connection, evicting it on failure. The
+// caller holds ep.mu.
+func (cp *ConnectionPool) write(ep *endpoint, data []byte) error {
+	conn := ep.conn.(net.Conn)
+	if err := conn.SetWriteDeadline(time.Now().Add(cp.Policy.WriteTimeout)); err != nil {
+		cp.evict(ep, ep.conn)
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		cp.evict(ep, ep.conn)
+		return err
+	}
+	return nil
+}
+
+// evict closes conn if it is still the current connection of ep. The caller
+// holds ep.mu.
+func (cp *ConnectionPool) evict(ep *endpoint, conn interface{}) {
+	if ep.conn != conn {
+		return
+	}
+	if closer, ok := conn.(io.Closer); ok {
+		closer.Close()
+	}
+	ep.conn = nil
+	cp.Connections.CompareAndDelete(ep.addr.String(), conn)
+	cp.Evictions.Update(func(evictions int) int { return evictions + 1 })
+}
+
+// watch reads from an outgoing connection, which peers never write to, so
+// that a peer closing or resetting it is noticed before the next send.
+func (cp *ConnectionPool) watch(ep *endpoint, conn net.Conn) {
+	io.Copy(io.Discard, conn)
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	cp.evict(ep, conn)
+}
+
+// enqueue holds data until ep is reachable again, dropping the oldest
+// message once the queue is full. The caller holds ep.mu.
+func (cp *ConnectionPool) enqueue(ep *endpoint, data []byte, cause error) error {
+	if cp.Policy.QueueSize <= 0 || cp.closed.Get() {
+		cp.Dropped.Update(func(dropped int) int { return dropped + 1 })
+		return fmt.Errorf("dropped message to %v: %v", ep.addr, cause)
+	}
+	if len(ep.queue) >= cp.Policy.QueueSize {
+		ep.queue = ep.queue[1:]
+		cp.Dropped.Update(func(dropped int) int { return dropped + 1 })
+	}
+	ep.queue = append(ep.queue, data)
+	if !ep.reconnecting {
+		ep.reconnecting = true
+		go cp.reconnect(ep)
+	}
+	return nil
+}
+
+// reconnect redials ep with backoff until its queue has been flushed.
+func (cp *ConnectionPool) reconnect(ep *endpoint) {
+	for {
+		ep.mu.Lock()
+		wait := time.Until(ep.nextAttempt)
+		ep.mu.Unlock()
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+
+		ep.mu.Lock()
+		if len(ep.queue) == 0 || cp.closed.Get() {
+			ep.queue = nil
+			ep.reconnecting = false
+			ep.mu.Unlock()
+			return
+		}
+		if ep.conn == nil {
+			if err := cp.connect(ep); err != nil {
+				ep.mu.Unlock()
+				continue
+			}
+		}
+		for len(ep.queue) > 0 {
+			if err := cp.write(ep, ep.queue[0]); err != nil {
+				break
+			}
+			ep.queue = ep.queue[1:]
+		}
+		if len(ep.queue) > 0 && ep.nextAttempt.IsZero() {
+			ep.failures++
+			ep.nextAttempt = time.Now().Add(utils.Backoff(cp.Policy.BackoffMin, cp.Policy.BackoffMax, ep.failures))
+		}
+		ep.mu.Unlock()
+	}
+}
+
+func newConnectionPool(ConnectionType ConnectionType, policy ReconnectPolicy, getNewConnection func(net.Addr) (interface{}, error)) *ConnectionPool {
+	if policy.DialTimeout <= 0 {
+		policy.DialTimeout = DefaultDialTimeout
+	}
+	if policy.WriteTimeout <= 0 {
+		policy.WriteTimeout = DefaultWriteTimeout
+	}
 	return &ConnectionPool{
-		Port:           Port,
-		ConnectionType: ConnectionType,
-		GetNewConnection: func(addr net.Addr, Port int) (interface{}, error) {
-			return GetUDPConnection(addr, Port)
-		},
+		ConnectionType:    ConnectionType,
+		GetNewConnection:  getNewConnection,
+		Policy:            policy,
+		ReconnectAttempts: datastructures.NewSafeValue(0),
+		Evictions:         datastructures.NewSafeValue(0),
+		Dropped:           datastructures.NewSafeValue(0),
+		closed:            datastructures.NewSafeValue(false),
 	}
 }
 
-func GetTCPConnection(addr net.Addr, port int) (net.Conn, error) {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", addr.(*net.TCPAddr).IP.String(), port))
+func NewTCPConnectionPool(ConnectionType ConnectionType, policy ReconnectPolicy) *ConnectionPool {
+	cp := newConnectionPool(ConnectionType, policy, nil)
+	cp.GetNewConnection = func(addr net.Addr) (interface{}, error) {
+		return GetTCPConnection(addr, cp.Policy.DialTimeout)
+	}
+	return cp
+}
+
+func NewUDPConnectionPool(ConnectionType ConnectionType, policy ReconnectPolicy) *ConnectionPool {
+	return newConnectionPool(ConnectionType, policy, func(addr net.Addr) (interface{}, error) {
+		return GetUDPConnection(addr)
+	})
+}
+
+func GetTCPConnection(addr net.Addr, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr.String(), timeout)
 	if err != nil {
 		return nil, err
 	}
 	return conn, nil
 }
 
-func GetUDPConnection(addr net.Addr, port int) (*net.UDPConn, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", addr.(*net.TCPAddr).IP.String(), port))
+func GetUDPConnection(addr net.Addr) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,252 @@
+package network
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"paxos/paxos/types"
+)
+
+// testPeer accepts connections and reports the payload of every frame
+// received on them.
+type testPeer struct {
+	listener net.Listener
+	received chan string
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func listen(t *testing.T, addr string) *testPeer {
+	t.Helper()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := &testPeer{listener: listener, received: make(chan string, 100)}
+	t.Cleanup(tp.close)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tp.mu.Lock()
+			tp.conns = append(tp.conns, conn)
+			tp.mu.Unlock()
+			go func() {
+				for {
+					frame, err := ReadFrame(conn)
+					if err != nil {
+						return
+					}
+					tp.received <- string(frame.Payload)
+				}
+			}()
+		}
+	}()
+	return tp
+}
+
+// dropConnections closes every connection accepted so far, as a peer that
+// restarts would.
+func (tp *testPeer) dropConnections() {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	for _, conn := range tp.conns {
+		conn.Close()
+	}
+	tp.conns = nil
+}
+
+func (tp *testPeer) close() {
+	tp.listener.Close()
+	tp.dropConnections()
+}
+
+func (tp *testPeer) expect(t *testing.T, payloads ...string) {
+	t.Helper()
+	for _, want := range payloads {
+		select {
+		case got := <-tp.received:
+			if got != want {
+				t.Fatalf("received %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive %q", want)
+		}
+	}
+}
+
+// unusedAddr returns a local address nothing is listening on.
+func unusedAddr(t *testing.T) net.Addr {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr()
+	listener.Close()
+	return addr
+}
+
+func frame(t *testing.T, payload string) []byte {
+	t.Helper()
+	data, err := EncodeFrame(Frame{Type: types.ACCEPT, SenderId: 1, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newTestPool(t *testing.T, policy ReconnectPolicy) *ConnectionPool {
+	cp := NewTCPConnectionPool(Outgoing, policy)
+	t.Cleanup(cp.Close)
+	return cp
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolEvictsAndRedialsClosedConnection(t *testing.T) {
+	peer := listen(t, "127.0.0.1:0")
+	addr := peer.listener.Addr()
+	cp := newTestPool(t, ReconnectPolicy{BackoffMin: 10 * time.Millisecond, BackoffMax: 10 * time.Millisecond})
+
+	if err := cp.Send(addr, frame(t, "first")); err != nil {
+		t.Fatal(err)
+	}
+	peer.expect(t, "first")
+
+	peer.dropConnections()
+	waitFor(t, "the closed connection to be evicted", func() bool { return cp.Evictions.Get() == 1 })
+	if _, ok := cp.Connections.Load(addr.String()); ok {
+		t.Error("evicted connection is still pooled")
+	}
+
+	if err := cp.Send(addr, frame(t, "second")); err != nil {
+		t.Fatal(err)
+	}
+	peer.expect(t, "second")
+	if reconnects := cp.Reconnects(addr); reconnects != 1 {
+		t.Errorf("Reconnects = %d, want 1", reconnects)
+	}
+}
+
+func TestPoolEvictsStalledConnection(t *testing.T) {
+	// The peer accepts connections but never reads from them.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	cp := newTestPool(t, ReconnectPolicy{
+		BackoffMin:   10 * time.Millisecond,
+		BackoffMax:   10 * time.Millisecond,
+		WriteTimeout: 50 * time.Millisecond,
+	})
+
+	data := make([]byte, 1<<20)
+	deadline := time.Now().Add(10 * time.Second)
+	for cp.Evictions.Get() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("a connection whose writes stall was never evicted")
+		}
+		start := time.Now()
+		cp.Send(listener.Addr(), data)
+		// A send writes at most twice: once on the stalled connection and
+		// once on a fresh one.
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("a send to a stalled peer took %v with a 50ms write timeout", elapsed)
+		}
+	}
+}
+
+func TestPoolBacksOffUnreachablePeer(t *testing.T) {
+	addr := unusedAddr(t)
+	cp := newTestPool(t, ReconnectPolicy{BackoffMin: time.Minute, BackoffMax: time.Minute})
+
+	if err := cp.Send(addr, frame(t, "first")); err == nil {
+		t.Fatal("sent to a peer that is not listening")
+	}
+	err := cp.Send(addr, frame(t, "second"))
+	if err == nil || !strings.Contains(err.Error(), "next attempt in") {
+		t.Fatalf("second send returned %v, want an error saying the peer is backing off", err)
+	}
+	if attempts := cp.ReconnectAttempts.Get(); attempts != 0 {
+		t.Errorf("redialled %d times within the backoff", attempts)
+	}
+	if dropped := cp.Dropped.Get(); dropped != 2 {
+		t.Errorf("Dropped = %d with no queue, want 2", dropped)
+	}
+}
+
+func TestPoolQueuesUntilReconnected(t *testing.T) {
+	addr := unusedAddr(t)
+	cp := newTestPool(t, ReconnectPolicy{
+		BackoffMin: 20 * time.Millisecond,
+		BackoffMax: 50 * time.Millisecond,
+		QueueSize:  2,
+	})
+
+	for _, payload := range []string{"first", "second", "third"} {
+		if err := cp.Send(addr, frame(t, payload)); err != nil {
+			t.Fatalf("queueing %s: %v", payload, err)
+		}
+	}
+	if dropped := cp.Dropped.Get(); dropped != 1 {
+		t.Errorf("Dropped = %d after overflowing a queue of 2 by one, want 1", dropped)
+	}
+
+	peer := listen(t, addr.String())
+	peer.expect(t, "second", "third")
+	waitFor(t, "the queue to be flushed", func() bool {
+		value, _ := cp.endpoints.Load(addr.String())
+		ep := value.(*endpoint)
+		ep.mu.Lock()
+		defer ep.mu.Unlock()
+		return !ep.reconnecting
+	})
+	if err := cp.Send(addr, frame(t, "fourth")); err != nil {
+		t.Fatal(err)
+	}
+	peer.expect(t, "fourth")
+	if reconnects := cp.Reconnects(addr); reconnects == 0 {
+		t.Error("the queue was flushed without a reconnect attempt")
+	}
+}
+
+func TestPoolCloseDropsQueue(t *testing.T) {
+	addr := unusedAddr(t)
+	cp := NewTCPConnectionPool(Outgoing, ReconnectPolicy{
+		BackoffMin: 10 * time.Millisecond,
+		BackoffMax: 10 * time.Millisecond,
+		QueueSize:  4,
+	})
+	if err := cp.Send(addr, frame(t, "queued")); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+	if err := cp.Send(addr, frame(t, "late")); err == nil {
+		t.Error("a closed pool accepted a message")
+	}
+}
@@ -0,0 +1,63 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"paxos/paxos/types"
+)
+
+// A frame is a 4-byte little-endian payload length, a 1-byte message type,
+// the 4-byte little-endian peer ID of the sender and the payload itself.
+const frameHeaderSize = 9
+
+const MaxFrameSize = 1 << 20
+
+var ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+
+type Frame struct {
+	Type     types.MessageType
+	SenderId int
+	Payload  []byte
+}
+
+// EncodeFrame returns the encoded frame so that it can be written to a
+// connection with a single Write.
+func EncodeFrame(frame Frame) ([]byte, error) {
+	if len(frame.Payload) > MaxFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(frame.Payload))
+	}
+	data := make([]byte, frameHeaderSize, frameHeaderSize+len(frame.Payload))
+	binary.LittleEndian.PutUint32(data, uint32(len(frame.Payload)))
+	data[4] = byte(frame.Type)
+	binary.LittleEndian.PutUint32(data[5:], uint32(frame.SenderId))
+	return append(data, frame.Payload...), nil
+}
+
+// ReadFrame reads exactly one frame. It returns io.EOF if the connection was
+// closed between frames, and io.ErrUnexpectedEOF if it was closed in the
+// middle of one.
+func ReadFrame(r io.Reader) (Frame, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Frame{}, err
+	}
+	length := binary.LittleEndian.Uint32(header[:4])
+	if length > MaxFrameSize {
+		return Frame{}, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	return Frame{
+		Type:     types.MessageType(header[4]),
+		SenderId: int(int32(binary.LittleEndian.Uint32(header[5:]))),
+		Payload:  payload,
+	}, nil
+}
@@ -0,0 +1,61 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"paxos/paxos/types"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{Type: types.PREPARE, SenderId: 1, Payload: []byte("first")},
+		{Type: types.ACCEPT_ACK, SenderId: 12, Payload: []byte{}},
+		{Type: types.LEARN, SenderId: -1, Payload: bytes.Repeat([]byte{0xff}, MaxFrameSize)},
+	}
+	var stream bytes.Buffer
+	for _, frame := range frames {
+		data, err := EncodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Write(data)
+	}
+	for i, want := range frames {
+		got, err := ReadFrame(&stream)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if got.Type != want.Type || got.SenderId != want.SenderId || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("frame %d: got type %v from %d with %d bytes, want type %v from %d with %d bytes",
+				i, got.Type, got.SenderId, len(got.Payload), want.Type, want.SenderId, len(want.Payload))
+		}
+	}
+	if _, err := ReadFrame(&stream); err != io.EOF {
+		t.Errorf("reading past the last frame: got %v, want io.EOF", err)
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	data, err := EncodeFrame(Frame{Type: types.ACCEPT, SenderId: 3, Payload: []byte("payload")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for length := 1; length < len(data); length++ {
+		if _, err := ReadFrame(bytes.NewReader(data[:length])); err != io.ErrUnexpectedEOF {
+			t.Errorf("frame truncated to %d bytes: got %v, want io.ErrUnexpectedEOF", length, err)
+		}
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	if _, err := EncodeFrame(Frame{Payload: make([]byte, MaxFrameSize+1)}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("encoding an oversized frame: got %v, want ErrFrameTooLarge", err)
+	}
+	header := []byte{0, 0, 0, 0xff, byte(types.PREPARE), 1, 0, 0, 0}
+	if _, err := ReadFrame(bytes.NewReader(header)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("reading an oversized frame: got %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -0,0 +1,76 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+
+	"paxos/paxos/config"
+	"paxos/paxos/types"
+)
+
+// MemoryNetwork connects MemoryTransports over channels, so a whole
+// cluster can run inside a single process.
+type MemoryNetwork struct {
+	transports sync.Map // map[int]*MemoryTransport
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{}
+}
+
+// NewTransport attaches the peer with the given ID to the network. It
+// satisfies TransportFactory.
+func (mn *MemoryNetwork) NewTransport(id int, hosts []config.PeerConfig) (Transport, error) {
+	t := &MemoryTransport{
+		id:      id,
+		network: mn,
+		receive: make(chan types.InboundMessage),
+		closed:  make(chan struct{}),
+	}
+	if _, loaded := mn.transports.LoadOrStore(id, t); loaded {
+		return nil, fmt.Errorf("peer %d is already attached to the network", id)
+	}
+	return t, nil
+}
+
+type MemoryTransport struct {
+	id        int
+	network   *MemoryNetwork
+	receive   chan types.InboundMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *MemoryTransport) Send(peerId int, messageType types.MessageType, data []byte) error {
+	value, ok := t.network.transports.Load(peerId)
+	if !ok {
+		return fmt.Errorf("peer %d is not attached to the network", peerId)
+	}
+	recipient := value.(*MemoryTransport)
+	message := types.InboundMessage{
+		Type:     messageType,
+		SenderId: t.id,
+		Data:     append([]byte(nil), data...),
+	}
+	select {
+	case recipient.receive <- message:
+		return nil
+	case <-recipient.closed:
+		return fmt.Errorf("peer %d is closed", peerId)
+	case <-t.closed:
+		return fmt.Errorf("transport is closed")
+	}
+}
+
+func (t *MemoryTransport) Receive() <-chan types.InboundMessage {
+	return t.receive
+}
+
+// Close detaches the peer from the network. Sends to it fail afterwards.
+func (t *MemoryTransport) Close() error {
+	t.closeOnce.Do(func() {
+		t.network.transports.Delete(t.id)
+		close(t.closed)
+	})
+	return nil
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"paxos/paxos/types"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	mn := NewMemoryNetwork()
+	first, err := mn.NewTransport(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := mn.NewTransport(2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mn.NewTransport(1, nil); err == nil {
+		t.Error("attached two transports with the same peer ID")
+	}
+
+	data := []byte("payload")
+	sent := make(chan error, 1)
+	go func() { sent <- first.Send(2, types.ACCEPT, data) }()
+	select {
+	case message := <-second.Receive():
+		if message.Type != types.ACCEPT || message.SenderId != 1 || string(message.Data) != "payload" {
+			t.Errorf("received %v from %d carrying %q, want accept from 1 carrying \"payload\"", message.Type, message.SenderId, message.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Send(3, types.ACCEPT, data); err == nil {
+		t.Error("sent to a peer that is not attached")
+	}
+}
+
+func TestMemoryTransportClose(t *testing.T) {
+	mn := NewMemoryNetwork()
+	first, _ := mn.NewTransport(1, nil)
+	second, _ := mn.NewTransport(2, nil)
+
+	// A send that nobody receives is released when the recipient closes.
+	sent := make(chan error, 1)
+	go func() { sent <- first.Send(2, types.PREPARE, nil) }()
+	time.Sleep(10 * time.Millisecond)
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-sent:
+		if err == nil {
+			t.Error("send to a peer that closed before receiving succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send stayed blocked after the recipient closed")
+	}
+	if err := first.Send(2, types.PREPARE, nil); err == nil {
+		t.Error("sent to a closed peer")
+	}
+	if _, err := mn.NewTransport(2, nil); err != nil {
+		t.Errorf("could not reattach a closed peer: %v", err)
+	}
+}
@@ -1,104 +1,341 @@
 package network
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"paxos/paxos/auth"
+	"paxos/paxos/codec"
+	"paxos/paxos/config"
 	"paxos/paxos/datastructures"
+	"paxos/paxos/storage"
 	"paxos/paxos/types"
 	"paxos/paxos/utils"
 )
 
-type PeerStore struct {
-	MinProposalNumber      *datastructures.SafeValue[int64]
-	AcceptedProposalNumber *datastructures.SafeValue[int64]
-	AcceptedValue          *datastructures.SafeValue[rune]
-	RoundNumber            *datastructures.SafeValue[int]
+type Peer struct {
+	Id                int
+	Roles             *datastructures.SafeList[types.Role]
+	Acceptors         *datastructures.SafeList[string]
+	Learners          sync.Map // map[int][]string, learners of each group this peer accepts for
+	LearnerGroups     sync.Map // map[int]int, phase-2 quorum of each group this peer learns for
+	LearnerAcceptors  sync.Map // map[int][]string, acceptors of each group this peer learns for
+	Peers             *datastructures.SafeList[string]
+	Storage           storage.Storage
+	RoundNumber       *datastructures.SafeValue[int]
+	HighestRound      *datastructures.SafeValue[int] // highest round a rival in this peer's group is known to have used
+	Group             int                            // group this peer proposes for, -1 if it is not a proposer
+	ProposerId        int                            // identity in this peer's proposal numbers, -1 if it is not a proposer
+	Codec             codec.Codec
+	Authenticator     *auth.Authenticator
+	Unauthenticated   *datastructures.SafeValue[int]
+	Transport         Transport
+	WriteChannel      chan types.OutboundMessage
+	Slot              *datastructures.SafeValue[int]
+	Pending           *datastructures.SafeList[*types.Command]
+	Log               sync.Map // map[types.LogKey][]byte
+	ProposalValue     *datastructures.SafeValue[[]byte]
+	Prepared          *datastructures.SafeValue[bool]
+	Running           *datastructures.SafeValue[bool]
+	PrepareQuorumSize *datastructures.SafeValue[int]
+	AcceptQuorumSize  *datastructures.SafeValue[int]
+	Phase             *datastructures.SafeValue[int]
+	Retries           *datastructures.SafeValue[int]
+	PrepareTimeout    time.Duration
+	AcceptTimeout     time.Duration
+	BackoffMin        time.Duration
+	BackoffMax        time.Duration
+	PrepareAck        sync.Map // map[types.InstanceKey]*datastructures.SafeMap[int, *types.PrepareAckMessage], keyed by acceptor ID
+	AcceptAck         sync.Map // map[types.InstanceKey]*datastructures.SafeMap[int, *types.AcceptAckMessage], keyed by acceptor ID
+	Proposals         sync.Map // map[types.InstanceKey]types.Proposal, what this peer sent in each accept
+	Learned           sync.Map // map[types.InstanceKey]*datastructures.SafeMap[int, bool], keyed by acceptor ID
+	Rejected          sync.Map // map[types.InstanceKey]bool
+	stopped           chan struct{}
+	stopOnce          sync.Once
+	closed            chan struct{}
+	closeOnce         sync.Once
+
+	// OnChosen, if set before Start, is called once for every slot this
+	// peer learns the chosen value of.
+	OnChosen func(group int, slot int, value []byte)
 }
 
-type Peer struct {
-	Id            int
-	Roles         *datastructures.SafeList[types.Role]
-	Acceptors     *datastructures.SafeList[string]
-	Peers         *datastructures.SafeList[string]
-	Store         *PeerStore
-	ProposerId    int
-	TCPEgress     *ConnectionPool
-	TCPIngress    *ConnectionPool
-	ReadChannel   chan types.InboundMessage
-	WriteChannel  chan types.OutboundMessage
-	ProposalValue *datastructures.SafeValue[rune]
-	QuorumSize    *datastructures.SafeValue[int]
-	PrepareAck    sync.Map
-	AcceptAck     sync.Map // map[string]types.AcceptAckMessage
+// Start begins proposing pending commands once the other peers have had
+// time to come up, unless ctx is cancelled first.
+func (p *Peer) Start(ctx context.Context) {
+	// If I am the proposer, send prepare to acceptors
+	select {
+	case <-time.After(1 * time.Second):
+	case <-ctx.Done():
+		return
+	}
+	if p.Stopped() {
+		return
+	}
+	p.Running.Set(true)
+	if p.ProposerId != -1 && p.Pending.Length() > 0 {
+		go p.Propose()
+	}
 }
 
-const tcpPort = 8080
+// Stop ends proposing. Replies to messages the peer is still handling are
+// sent until it is closed.
+func (p *Peer) Stop() {
+	p.stopOnce.Do(func() {
+		p.Running.Set(false)
+		close(p.stopped)
+	})
+}
 
-func (p *Peer) Start() {
-	go p.ListenForTCPConnections()
-	// If I am the proposer, send prepare to acceptors
-	time.Sleep(1 * time.Second)
-	if p.ProposerId != -1 {
-		go p.SendPrepare()
+func (p *Peer) Stopped() bool {
+	select {
+	case <-p.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the peer, closes its connections and flushes and closes its
+// storage. Messages the peer tries to send afterwards are dropped, so
+// goroutines still handling earlier messages can finish.
+func (p *Peer) Close() error {
+	p.Stop()
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return errors.Join(p.Transport.Close(), p.Storage.Close())
+}
+
+// Append queues a command for the replicated log. The returned channel
+// receives the slot the command was chosen at.
+func (p *Peer) Append(value []byte) <-chan int {
+	command := &types.Command{
+		Value: value,
+		Index: make(chan int, 1),
+	}
+	p.Pending.Add(command)
+	if p.ProposerId != -1 && p.Running.Get() && p.Pending.Length() == 1 {
+		go p.Propose()
+	}
+	return command.Index
+}
+
+// Decide records the value this peer proposed at n as chosen for slot, and
+// moves the proposer on to the next slot if it still has pending commands.
+func (p *Peer) Decide(slot int, n int64) {
+	value, ok := p.Proposals.Load(types.InstanceKey{Slot: slot, N: n})
+	if !ok {
+		return
+	}
+	proposal := value.(types.Proposal)
+	if _, loaded := p.Log.LoadOrStore(types.LogKey{Group: p.Group, Slot: slot}, proposal.Value); !loaded {
+		roundNumber, serverId := utils.SplitN(n)
+		p.LogMessage(
+			"chose",
+			"chose",
+			slot,
+			proposal.Value,
+			p.Id,
+			fmt.Sprintf(
+				"%d.%d",
+				roundNumber,
+				serverId,
+			),
+		)
+		if p.OnChosen != nil {
+			p.OnChosen(p.Group, slot, proposal.Value)
+		}
+	}
+	// Move past the slot before anyone hears of the decision, so that a
+	// command appended in response is never proposed for the decided slot.
+	p.Slot.Update(func(next int) int { return max(next, slot+1) })
+	p.Phase.Update(func(phase int) int { return phase + 1 })
+	p.Retries.Set(0)
+	p.forget(slot)
+	var decided *types.Command
+	if command, ok := p.Pending.Get(0); ok && proposal.Command == command {
+		p.Pending.Remove(0)
+		decided = command
+	}
+	if p.Pending.Length() > 0 {
+		go p.Propose()
+	}
+	if decided != nil {
+		decided.Index <- slot
+	}
+}
+
+// forget drops the proposals, acks and nacks of slot and any earlier slot,
+// which are decided and never counted again.
+func (p *Peer) forget(slot int) {
+	for _, instances := range []*sync.Map{&p.PrepareAck, &p.AcceptAck, &p.Proposals, &p.Rejected} {
+		instances.Range(func(key, _ any) bool {
+			if key.(types.InstanceKey).Slot <= slot {
+				instances.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// Propose starts an instance for the next pending command. While a quorum
+// still honours this proposer's last prepare, phase 1 is skipped and the
+// command goes straight to the acceptors.
+func (p *Peer) Propose() {
+	command, ok := p.Pending.Get(0)
+	if !ok || !p.Running.Get() {
+		return
+	}
+	if !p.Prepared.Get() {
+		p.SendPrepare()
+		return
+	}
+	p.ProposalValue.Set(command.Value)
+	p.SendAccept(p.Slot.Get(), p.RoundNumber.Get(), command.Value, command)
+}
+
+// Retry abandons the current phase and, after a randomized exponential
+// backoff, restarts phase 1 with a round above promisedRound.
+func (p *Peer) Retry(promisedRound int) {
+	phase := p.Phase.Update(func(phase int) int { return phase + 1 })
+	p.Prepared.Set(false)
+	retries := p.Retries.Update(func(retries int) int { return retries + 1 })
+	select {
+	case <-time.After(utils.Backoff(p.BackoffMin, p.BackoffMax, retries)):
+	case <-p.stopped:
+		return
+	}
+	if p.Phase.Get() != phase || !p.Running.Get() {
+		return
 	}
+	p.HighestRound.Update(func(round int) int { return max(round, promisedRound) })
+	p.SendPrepare()
+}
+
+// startTimer retries the proposal if no further progress has been made
+// once the timeout elapses.
+func (p *Peer) startTimer(timeout time.Duration) {
+	phase := p.Phase.Update(func(phase int) int { return phase + 1 })
+	time.AfterFunc(timeout, func() {
+		if p.Phase.Get() == phase {
+			p.Retry(0)
+		}
+	})
+}
+
+// Learn records a value a learner has seen accepted by a quorum of group's
+// acceptors.
+func (p *Peer) Learn(group int, slot int, value []byte, proposalNumber string) {
+	if _, loaded := p.Log.LoadOrStore(types.LogKey{Group: group, Slot: slot}, value); loaded {
+		return
+	}
+	p.LogMessage(
+		"learned",
+		"learned",
+		slot,
+		value,
+		p.Id,
+		proposalNumber,
+	)
+	p.Learned.Range(func(key, _ any) bool {
+		if instance := key.(types.InstanceKey); instance.Group == group && instance.Slot == slot {
+			p.Learned.Delete(key)
+		}
+		return true
+	})
+	if p.OnChosen != nil {
+		p.OnChosen(group, slot, value)
+	}
+}
+
+func (p *Peer) Chosen(group int, slot int) ([]byte, bool) {
+	value, ok := p.Log.Load(types.LogKey{Group: group, Slot: slot})
+	if !ok {
+		return nil, false
+	}
+	return value.([]byte), true
 }
 
 func (p *Peer) SendPrepare() {
-	p.Store.RoundNumber.Set(p.Store.RoundNumber.Get() + 1)
+	command, ok := p.Pending.Get(0)
+	if !ok {
+		return
+	}
+	// Move to the new round first, so that a late ack for the old one can no
+	// longer match while the proposal is being reset.
+	roundNumber := p.RoundNumber.Update(func(round int) int { return max(round, p.HighestRound.Get()) + 1 })
+	p.ProposalValue.Set(command.Value)
+	p.Prepared.Set(false)
 	prepareMessage := types.PrepareMessage{
+		Group: datastructures.NewSafeValue(p.Group),
+		Slot:  datastructures.NewSafeValue(p.Slot.Get()),
 		ProposalNumber: &types.ProposalNumber{
-			RoundNumber: datastructures.NewSafeValue(p.Store.RoundNumber.Get()),
-			ServerId:    datastructures.NewSafeValue(p.Id),
+			RoundNumber: datastructures.NewSafeValue(roundNumber),
+			ServerId:    datastructures.NewSafeValue(p.ProposerId),
 		},
 		ProposalValue: p.ProposalValue,
 	}
-	data := types.Serialize(
-		int(types.PREPARE),
-		prepareMessage.ProposalNumber.RoundNumber.Get(),
-		prepareMessage.ProposalNumber.ServerId.Get(),
-		int(prepareMessage.ProposalValue.Get()),
-	)
+	data, err := p.Codec.EncodePrepare(&prepareMessage)
+	if err != nil {
+		fmt.Println("Error encoding prepare message:", err)
+		return
+	}
 	for _, acceptor := range p.Acceptors.GetAll() {
-		go p.SendMessageToPeer(acceptor, data)
+		go p.SendMessageToPeer(acceptor, types.PREPARE, data)
 		p.LogMessage(
 			"sent",
 			"prepare",
+			prepareMessage.Slot.Get(),
 			p.ProposalValue.Get(),
 			p.Id,
 			fmt.Sprintf(
 				"%d.%d",
-				p.Store.RoundNumber.Get(),
-				p.Id,
+				roundNumber,
+				p.ProposerId,
 			),
 		)
 	}
+	p.startTimer(p.PrepareTimeout)
 }
 
-func (p *Peer) SendPrepareAck(sender string) {
-	acceptedRoundNumber, acceptedServerId := utils.SplitN(p.Store.AcceptedProposalNumber.Get())
+// SendPrepareAck answers the prepare for proposal n with what this peer has
+// accepted in slot.
+func (p *Peer) SendPrepareAck(senderId int, group int, slot int, n int64) {
+	roundNumber, serverId := utils.SplitN(n)
+	acceptedN, acceptedValue := p.Storage.GetAccepted(group, slot)
+	acceptedRoundNumber, acceptedServerId := utils.SplitN(acceptedN)
 	prepareAckMessage := types.PrepareAckMessage{
+		Slot: datastructures.NewSafeValue(slot),
+		ProposalNumber: &types.ProposalNumber{
+			RoundNumber: datastructures.NewSafeValue(int(roundNumber)),
+			ServerId:    datastructures.NewSafeValue(int(serverId)),
+		},
 		AcceptedProposalNumber: &types.ProposalNumber{
 			RoundNumber: datastructures.NewSafeValue(int(acceptedRoundNumber)),
 			ServerId:    datastructures.NewSafeValue(int(acceptedServerId)),
 		},
-		AcceptedValue: datastructures.NewSafeValue(p.Store.AcceptedValue.Get()),
+		AcceptedValue:  datastructures.NewSafeValue(acceptedValue),
+		NoMoreAccepted: datastructures.NewSafeValue(!p.acceptedAfter(group, slot)),
 	}
-	data := types.Serialize(
-		int(types.PREPARE_ACK),
-		prepareAckMessage.AcceptedProposalNumber.RoundNumber.Get(),
-		prepareAckMessage.AcceptedProposalNumber.ServerId.Get(),
-		int(prepareAckMessage.AcceptedValue.Get()),
-	)
-	p.SendMessageToPeer(sender, data)
+	data, err := p.Codec.EncodePrepareAck(&prepareAckMessage)
+	if err != nil {
+		fmt.Println("Error encoding prepare_ack message:", err)
+		return
+	}
+	p.SendMessageToId(senderId, types.PREPARE_ACK, data)
 	p.LogMessage(
 		"sent",
 		"prepare_ack",
-		rune(prepareAckMessage.AcceptedValue.Get()),
+		slot,
+		prepareAckMessage.AcceptedValue.Get(),
 		p.Id,
 		fmt.Sprintf(
 			"%d.%d",
@@ -108,26 +345,34 @@ func (p *Peer) SendPrepareAck(sender string) {
 	)
 }
 
-func (p *Peer) SendAccept() {
+// SendAccept proposes value for slot at roundNumber, and records it so that
+// the value decided is the one the acceptors accepted. command is the pending
+// command value came from, or nil if value was adopted from an acceptor.
+func (p *Peer) SendAccept(slot int, roundNumber int, value []byte, command *types.Command) {
+	// Only one value is ever sent for a slot and round.
+	key := types.InstanceKey{Slot: slot, N: utils.GetN(int32(roundNumber), int32(p.ProposerId))}
+	stored, _ := p.Proposals.LoadOrStore(key, types.Proposal{Value: value, Command: command})
 	acceptMessage := types.AcceptMessage{
+		Group: datastructures.NewSafeValue(p.Group),
+		Slot:  datastructures.NewSafeValue(slot),
 		ProposalNumber: &types.ProposalNumber{
-			RoundNumber: datastructures.NewSafeValue(p.Store.RoundNumber.Get()),
-			ServerId:    datastructures.NewSafeValue(p.Id),
+			RoundNumber: datastructures.NewSafeValue(roundNumber),
+			ServerId:    datastructures.NewSafeValue(p.ProposerId),
 		},
-		ProposalValue: p.ProposalValue,
+		ProposalValue: datastructures.NewSafeValue(stored.(types.Proposal).Value),
+	}
+	data, err := p.Codec.EncodeAccept(&acceptMessage)
+	if err != nil {
+		fmt.Println("Error encoding accept message:", err)
+		return
 	}
-	data := types.Serialize(
-		int(types.ACCEPT),
-		acceptMessage.ProposalNumber.RoundNumber.Get(),
-		acceptMessage.ProposalNumber.ServerId.Get(),
-		int(acceptMessage.ProposalValue.Get()),
-	)
 	for _, acceptor := range p.Acceptors.GetAll() {
-		p.SendMessageToPeer(acceptor, data)
+		p.SendMessageToPeer(acceptor, types.ACCEPT, data)
 		p.LogMessage(
 			"sent",
 			"accept",
-			rune(acceptMessage.ProposalValue.Get()),
+			acceptMessage.Slot.Get(),
+			acceptMessage.ProposalValue.Get(),
 			p.Id,
 			fmt.Sprintf(
 				"%d.%d",
@@ -136,26 +381,31 @@ func (p *Peer) SendAccept() {
 			),
 		)
 	}
+	p.startTimer(p.AcceptTimeout)
 }
 
-func (p *Peer) SendAcceptAck(sender string) {
-	roundNumber, serverId := utils.SplitN(p.Store.MinProposalNumber.Get())
+// SendAcceptAck tells the proposer that this peer accepted proposal n in
+// slot.
+func (p *Peer) SendAcceptAck(senderId int, group int, slot int, n int64) {
+	roundNumber, serverId := utils.SplitN(n)
 	acceptAckMessage := types.AcceptAckMessage{
+		Slot: datastructures.NewSafeValue(slot),
 		ProposalNumber: &types.ProposalNumber{
 			RoundNumber: datastructures.NewSafeValue(int(roundNumber)),
 			ServerId:    datastructures.NewSafeValue(int(serverId)),
 		},
 	}
-	data := types.Serialize(
-		int(types.ACCEPT_ACK),
-		acceptAckMessage.ProposalNumber.RoundNumber.Get(),
-		acceptAckMessage.ProposalNumber.ServerId.Get(),
-	)
-	p.SendMessageToPeer(sender, data)
+	data, err := p.Codec.EncodeAcceptAck(&acceptAckMessage)
+	if err != nil {
+		fmt.Println("Error encoding accept_ack message:", err)
+		return
+	}
+	p.SendMessageToId(senderId, types.ACCEPT_ACK, data)
 	p.LogMessage(
 		"sent",
 		"accept_ack",
-		p.Store.AcceptedValue.Get(),
+		slot,
+		p.acceptedValue(group, slot),
 		p.Id,
 		fmt.Sprintf(
 			"%d.%d",
@@ -165,114 +415,348 @@ func (p *Peer) SendAcceptAck(sender string) {
 	)
 }
 
-func (p *Peer) SendMessageToPeer(peer string, data []byte) {
-	addr, err := utils.GetAddrFromHostname(peer)
+func (p *Peer) SendPrepareNack(senderId int, group int, slot int, n int64) {
+	roundNumber, serverId := utils.SplitN(n)
+	promisedRoundNumber, promisedServerId := utils.SplitN(p.Storage.GetPromise(group))
+	prepareNackMessage := types.PrepareNackMessage{
+		Slot: datastructures.NewSafeValue(slot),
+		ProposalNumber: &types.ProposalNumber{
+			RoundNumber: datastructures.NewSafeValue(int(roundNumber)),
+			ServerId:    datastructures.NewSafeValue(int(serverId)),
+		},
+		PromisedProposalNumber: &types.ProposalNumber{
+			RoundNumber: datastructures.NewSafeValue(int(promisedRoundNumber)),
+			ServerId:    datastructures.NewSafeValue(int(promisedServerId)),
+		},
+	}
+	data, err := p.Codec.EncodePrepareNack(&prepareNackMessage)
+	if err != nil {
+		fmt.Println("Error encoding prepare_nack message:", err)
+		return
+	}
+	p.SendMessageToId(senderId, types.PREPARE_NACK, data)
+	p.LogMessage(
+		"sent",
+		"prepare_nack",
+		slot,
+		p.acceptedValue(group, slot),
+		p.Id,
+		fmt.Sprintf(
+			"%d.%d",
+			prepareNackMessage.PromisedProposalNumber.RoundNumber.Get(),
+			prepareNackMessage.PromisedProposalNumber.ServerId.Get(),
+		),
+	)
+}
+
+func (p *Peer) SendAcceptNack(senderId int, group int, slot int, n int64) {
+	roundNumber, serverId := utils.SplitN(n)
+	promisedRoundNumber, promisedServerId := utils.SplitN(p.Storage.GetPromise(group))
+	acceptNackMessage := types.AcceptNackMessage{
+		Slot: datastructures.NewSafeValue(slot),
+		ProposalNumber: &types.ProposalNumber{
+			RoundNumber: datastructures.NewSafeValue(int(roundNumber)),
+			ServerId:    datastructures.NewSafeValue(int(serverId)),
+		},
+		PromisedProposalNumber: &types.ProposalNumber{
+			RoundNumber: datastructures.NewSafeValue(int(promisedRoundNumber)),
+			ServerId:    datastructures.NewSafeValue(int(promisedServerId)),
+		},
+	}
+	data, err := p.Codec.EncodeAcceptNack(&acceptNackMessage)
+	if err != nil {
+		fmt.Println("Error encoding accept_nack message:", err)
+		return
+	}
+	p.SendMessageToId(senderId, types.ACCEPT_NACK, data)
+	p.LogMessage(
+		"sent",
+		"accept_nack",
+		slot,
+		p.acceptedValue(group, slot),
+		p.Id,
+		fmt.Sprintf(
+			"%d.%d",
+			acceptNackMessage.PromisedProposalNumber.RoundNumber.Get(),
+			acceptNackMessage.PromisedProposalNumber.ServerId.Get(),
+		),
+	)
+}
+
+// SendLearn tells the learners of group what this peer accepted for it in
+// slot.
+func (p *Peer) SendLearn(group int, slot int) {
+	learners, ok := p.Learners.Load(group)
+	if !ok {
+		return
+	}
+	acceptedN, acceptedValue := p.Storage.GetAccepted(group, slot)
+	roundNumber, serverId := utils.SplitN(acceptedN)
+	learnMessage := types.LearnMessage{
+		Group: datastructures.NewSafeValue(group),
+		Slot:  datastructures.NewSafeValue(slot),
+		ProposalNumber: &types.ProposalNumber{
+			RoundNumber: datastructures.NewSafeValue(int(roundNumber)),
+			ServerId:    datastructures.NewSafeValue(int(serverId)),
+		},
+		AcceptedValue: datastructures.NewSafeValue(acceptedValue),
+	}
+	data, err := p.Codec.EncodeLearn(&learnMessage)
 	if err != nil {
-		fmt.Printf("error resolving address for host %s: %v\n", peer, err)
+		fmt.Println("Error encoding learn message:", err)
 		return
 	}
-	p.WriteChannel <- types.OutboundMessage{
-		Data:      data,
-		Recipient: addr,
+	for _, learner := range learners.([]string) {
+		p.SendMessageToPeer(learner, types.LEARN, data)
+		p.LogMessage(
+			"sent",
+			"learn",
+			slot,
+			learnMessage.AcceptedValue.Get(),
+			p.Id,
+			fmt.Sprintf(
+				"%d.%d",
+				learnMessage.ProposalNumber.RoundNumber.Get(),
+				learnMessage.ProposalNumber.ServerId.Get(),
+			),
+		)
 	}
 }
 
-func (p *Peer) ListenForTCPConnections() {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", tcpPort))
+// AcceptsFor reports whether this peer is an acceptor for group.
+func (p *Peer) AcceptsFor(group int) bool {
+	_, ok := p.Learners.Load(group)
+	return ok
+}
+
+func (p *Peer) acceptedValue(group int, slot int) []byte {
+	_, value := p.Storage.GetAccepted(group, slot)
+	return value
+}
+
+func (p *Peer) acceptedAfter(group int, slot int) bool {
+	return p.Storage.HighestAcceptedSlot(group) > slot
+}
+
+// SendMessageToPeer sends to the peer listed under hostname peer.
+func (p *Peer) SendMessageToPeer(peer string, messageType types.MessageType, data []byte) {
+	peerId, err := utils.GetPeerIdFromName(peer, p.Peers.GetAll())
 	if err != nil {
-		fmt.Println("Error starting TCP listener:", err)
+		fmt.Printf("error identifying host %s: %v\n", peer, err)
 		return
 	}
-	defer listener.Close()
+	p.SendMessageToId(peerId, messageType, data)
+}
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Println("Error accepting connection:", err)
-			continue
-		}
-		p.TCPIngress.Add(conn.RemoteAddr(), conn)
-		go p.HandleTCPConnection(conn)
+func (p *Peer) SendMessageToId(peerId int, messageType types.MessageType, data []byte) {
+	if p.Authenticator != nil {
+		data = p.Authenticator.Sign(messageType, p.Id, data)
+	}
+	select {
+	case p.WriteChannel <- types.OutboundMessage{
+		Type:        messageType,
+		Data:        data,
+		RecipientId: peerId,
+	}:
+	case <-p.closed:
 	}
 }
 
-func (p *Peer) HandleTCPConnection(conn net.Conn) {
-	defer conn.Close()
-	buffer := make([]byte, 1024)
+func NewPeer(cfg *config.Config, cluster *config.ClusterConfig) (*Peer, error) {
+	hostname, err := GetName(cfg, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	protocol, err := types.ParseProtocol(cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		n, err := conn.Read(buffer)
+	var tlsConfig *tls.Config
+	if cfg.TLSCert != "" || cfg.TLSKey != "" || cfg.TLSCA != "" {
+		if cfg.TLSCert == "" || cfg.TLSKey == "" || cfg.TLSCA == "" {
+			return nil, fmt.Errorf("mutual TLS needs a certificate, a key and a CA")
+		}
+		if protocol != types.TCP {
+			return nil, fmt.Errorf("mutual TLS is only supported by the tcp transport")
+		}
+		tlsConfig, err = LoadTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSCA)
 		if err != nil {
-			if err != io.EOF {
-				fmt.Println("Error reading from TCP connection:", err)
-			}
-			break
+			return nil, err
 		}
-		p.ReadChannel <- types.InboundMessage{
-			Data:   buffer[:n],
-			Sender: conn.RemoteAddr(),
+	}
+
+	var store storage.Storage = storage.NewMemoryStorage()
+	if cfg.DataDir != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %v", err)
+		}
+		fileStorage, err := storage.NewFileStorage(filepath.Join(cfg.DataDir, "acceptor.wal"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open write-ahead log: %v", err)
 		}
+		store = fileStorage
 	}
+
+	return NewPeerWithTransport(cfg, cluster, hostname, store, NewTransportFactory(protocol, ReconnectPolicy{
+		BackoffMin:   cfg.ReconnectMin,
+		BackoffMax:   cfg.ReconnectMax,
+		QueueSize:    cfg.SendQueueSize,
+		DialTimeout:  cfg.DialTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}, tlsConfig))
 }
 
-func NewPeer(hostsFile string, proposalValue rune) (*Peer, error) {
+// GetName returns the hosts file entry this peer runs as: the one selected
+// by -name or -id, or the machine's hostname if neither is set.
+func GetName(cfg *config.Config, cluster *config.ClusterConfig) (string, error) {
+	if cfg.Name != "" && cfg.Id != 0 {
+		return "", fmt.Errorf("set either a name or an id, not both")
+	}
+	if cfg.Name != "" {
+		return cfg.Name, nil
+	}
+	if cfg.Id != 0 {
+		return utils.GetPeerNameFromId(cfg.Id, cluster.PeerNames())
+	}
 	hostname, err := os.Hostname()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get hostname: %v", err)
+		return "", fmt.Errorf("failed to get hostname: %v", err)
 	}
+	return hostname, nil
+}
 
-	peers, err := utils.GetPeers(hostsFile)
+// NewPeerWithTransport creates the peer listed under hostname in the hosts
+// file, whose acceptor state is kept in store and whose messages travel over
+// the transport built by newTransport.
+func NewPeerWithTransport(cfg *config.Config, cluster *config.ClusterConfig, hostname string, store storage.Storage, newTransport TransportFactory) (*Peer, error) {
+	peers := cluster.PeerNames()
+
+	id, err := utils.GetPeerIdFromName(hostname, peers)
 	if err != nil {
 		return nil, err
 	}
 
-	roles, err := utils.GetRoles(hostname, hostsFile)
+	groupId, proposerId := -1, -1
+	var acceptors []string
+	prepareQuorumSize, acceptQuorumSize := 0, 0
+	proposerGroups := cluster.GroupsForRole(hostname, types.Proposer)
+	if len(proposerGroups) > 1 {
+		return nil, fmt.Errorf("peer %s proposes for groups %v, but a peer can propose for only one group", hostname, proposerGroups)
+	}
+	if len(proposerGroups) == 1 {
+		groupId = proposerGroups[0]
+		group, _ := cluster.Group(groupId)
+		proposerId = cluster.ProposerId(group, hostname)
+		acceptors = group.Acceptors
+		prepareQuorumSize, acceptQuorumSize, err = getQuorumSizes(cfg, group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	messageCodec, err := codec.NewCodec(cfg.Codec)
 	if err != nil {
 		return nil, err
 	}
 
-	proposerId, err := utils.GetProposerId(hostname, hostsFile)
-	if err != nil {
-		proposerId = -1
+	var authenticator *auth.Authenticator
+	if cfg.ClusterKeyFile != "" {
+		authenticator, err = auth.LoadAuthenticator(cfg.ClusterKeyFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	acceptors, err := utils.GetAcceptorsForProposer(proposerId, hostsFile)
-	if err != nil {
-		return nil, err
+	peer := &Peer{
+		Id:                id,
+		Roles:             datastructures.NewSafeList(cluster.Roles(hostname)),
+		Acceptors:         datastructures.NewSafeList(acceptors),
+		Peers:             datastructures.NewSafeList(peers),
+		Storage:           store,
+		RoundNumber:       datastructures.NewSafeValue(0),
+		HighestRound:      datastructures.NewSafeValue(0),
+		Group:             groupId,
+		ProposerId:        proposerId,
+		Codec:             messageCodec,
+		Authenticator:     authenticator,
+		Unauthenticated:   datastructures.NewSafeValue(0),
+		Slot:              datastructures.NewSafeValue(1),
+		Pending:           datastructures.NewSafeList(make([]*types.Command, 0)),
+		ProposalValue:     datastructures.NewSafeValue[[]byte](nil),
+		Prepared:          datastructures.NewSafeValue(false),
+		Running:           datastructures.NewSafeValue(false),
+		PrepareQuorumSize: datastructures.NewSafeValue(prepareQuorumSize),
+		AcceptQuorumSize:  datastructures.NewSafeValue(acceptQuorumSize),
+		Phase:             datastructures.NewSafeValue(0),
+		Retries:           datastructures.NewSafeValue(0),
+		PrepareTimeout:    getDuration(cluster.PrepareTimeout, cfg.PrepareTimeout),
+		AcceptTimeout:     getDuration(cluster.AcceptTimeout, cfg.AcceptTimeout),
+		BackoffMin:        getDuration(cluster.BackoffMin, cfg.BackoffMin),
+		BackoffMax:        getDuration(cluster.BackoffMax, cfg.BackoffMax),
+		WriteChannel:      make(chan types.OutboundMessage),
+		stopped:           make(chan struct{}),
+		closed:            make(chan struct{}),
 	}
 
-	id, err := utils.GetPeerIdFromName(hostname, peers)
+	for _, groupId := range cluster.GroupsForRole(hostname, types.Acceptor) {
+		group, _ := cluster.Group(groupId)
+		peer.Learners.Store(groupId, group.Learners)
+	}
+
+	for _, groupId := range cluster.GroupsForRole(hostname, types.Learner) {
+		group, _ := cluster.Group(groupId)
+		_, groupAcceptQuorumSize, err := getQuorumSizes(cfg, group)
+		if err != nil {
+			return nil, err
+		}
+		peer.LearnerGroups.Store(groupId, groupAcceptQuorumSize)
+		peer.LearnerAcceptors.Store(groupId, group.Acceptors)
+	}
+
+	transport, err := newTransport(id, cluster.Peers)
 	if err != nil {
 		return nil, err
 	}
+	peer.Transport = transport
+
+	return peer, nil
+}
+
+// getQuorumSizes returns the phase-1 and phase-2 quorum sizes of group. Sizes
+// set in the cluster config take precedence over the command line.
+func getQuorumSizes(cfg *config.Config, group config.GroupConfig) (int, int, error) {
+	prepareQuorumSize := group.PrepareQuorum
+	if prepareQuorumSize == 0 {
+		prepareQuorumSize = cfg.PrepareQuorumSize
+	}
+	acceptQuorumSize := group.AcceptQuorum
+	if acceptQuorumSize == 0 {
+		acceptQuorumSize = cfg.AcceptQuorumSize
+	}
+	prepareQuorumSize = utils.GetQuorumSize(len(group.Acceptors), prepareQuorumSize)
+	acceptQuorumSize = utils.GetQuorumSize(len(group.Acceptors), acceptQuorumSize)
+	if err := utils.ValidateQuorums(len(group.Acceptors), prepareQuorumSize, acceptQuorumSize); err != nil {
+		return 0, 0, fmt.Errorf("invalid quorums for group %d: %v", group.Id, err)
+	}
+	return prepareQuorumSize, acceptQuorumSize, nil
+}
 
-	store := &PeerStore{
-		MinProposalNumber:      datastructures.NewSafeValue(utils.GetN(0, int32(id))),
-		AcceptedProposalNumber: datastructures.NewSafeValue(utils.GetN(0, int32(id))),
-		AcceptedValue:          datastructures.NewSafeValue[rune](0),
-		RoundNumber:            datastructures.NewSafeValue(0),
-	}
-
-	return &Peer{
-		Id:            id,
-		Roles:         datastructures.NewSafeList(roles),
-		Acceptors:     datastructures.NewSafeList(acceptors),
-		Peers:         datastructures.NewSafeList(peers),
-		Store:         store,
-		ProposerId:    proposerId,
-		TCPIngress:    NewTCPConnectionPool(tcpPort, Incoming),
-		TCPEgress:     NewTCPConnectionPool(tcpPort, Outgoing),
-		ProposalValue: datastructures.NewSafeValue(proposalValue),
-		QuorumSize:    datastructures.NewSafeValue(len(acceptors)),
-		ReadChannel:   make(chan types.InboundMessage),
-		WriteChannel:  make(chan types.OutboundMessage),
-	}, nil
+// getDuration returns the cluster-wide setting if the cluster config has
+// one, and the command line value otherwise.
+func getDuration(clusterValue config.Duration, flagValue time.Duration) time.Duration {
+	if clusterValue > 0 {
+		return time.Duration(clusterValue)
+	}
+	return flagValue
 }
 
-func (p *Peer) LogMessage(action, messageType string, messageValue rune, peerId int, proposalNumber string) {
+func (p *Peer) LogMessage(action, messageType string, slot int, messageValue []byte, peerId int, proposalNumber string) {
+	encodedValue, _ := json.Marshal(string(messageValue))
 	logMessage := fmt.Sprintf(
-		`{"peer_id":%d, "action": "%s", "message_type":"%s", "message_value":"%c", "proposal_num":%s}`,
-		peerId, action, messageType, messageValue, proposalNumber,
+		`{"peer_id":%d, "action": "%s", "message_type":"%s", "slot":%d, "message_value":%s, "proposal_num":%s}`,
+		peerId, action, messageType, slot, encodedValue, proposalNumber,
 	)
 	utils.PrintToStderr(logMessage)
 }
@@ -0,0 +1,20 @@
+package network
+
+import (
+	"strings"
+	"testing"
+
+	"paxos/paxos/config"
+	"paxos/paxos/storage"
+)
+
+func TestNewPeerRejectsProposerInSeveralGroups(t *testing.T) {
+	cluster, err := config.ParseHostsFile([]byte("p:proposer1,proposer2\na:acceptor1,acceptor2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPeerWithTransport(&config.Config{Codec: "binary"}, cluster, "p", storage.NewMemoryStorage(), NewMemoryNetwork().NewTransport)
+	if err == nil || !strings.Contains(err.Error(), "propose for only one group") {
+		t.Errorf("NewPeerWithTransport returned %v, want an error about proposing for two groups", err)
+	}
+}
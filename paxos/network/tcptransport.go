@@ -0,0 +1,156 @@
+package network
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"paxos/paxos/config"
+	"paxos/paxos/types"
+	"paxos/paxos/utils"
+)
+
+// TCPTransport sends length-prefixed frames over one outgoing TCP
+// connection per peer. With a TLS config, connections in both directions
+// use mutual TLS and every peer's certificate must be issued for its
+// hosts-file name.
+type TCPTransport struct {
+	id        int
+	hosts     []config.PeerConfig
+	listener  net.Listener
+	tlsConfig *tls.Config
+	hostnames sync.Map // map[string]string, address to hosts-file name
+	ingress   *ConnectionPool
+	Egress    *ConnectionPool
+	receive   chan types.InboundMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewTCPTransport(id int, hosts []config.PeerConfig, policy ReconnectPolicy, tlsConfig *tls.Config) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", hosts[id-1].Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start TCP listener: %v", err)
+	}
+	t := &TCPTransport{
+		id:        id,
+		hosts:     hosts,
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		ingress:   NewTCPConnectionPool(Incoming, ReconnectPolicy{}),
+		Egress:    NewTCPConnectionPool(Outgoing, policy),
+		receive:   make(chan types.InboundMessage),
+		closed:    make(chan struct{}),
+	}
+	if tlsConfig != nil {
+		t.listener = tls.NewListener(listener, tlsConfig)
+		t.Egress.GetNewConnection = func(addr net.Addr) (interface{}, error) {
+			hostname, _ := t.hostnames.Load(addr.String())
+			name, _ := hostname.(string)
+			return GetTLSConnection(addr, name, tlsConfig, t.Egress.Policy.DialTimeout)
+		}
+	}
+	go t.listen()
+	return t, nil
+}
+
+func (t *TCPTransport) Send(peerId int, messageType types.MessageType, data []byte) error {
+	if peerId <= 0 || peerId > len(t.hosts) {
+		return fmt.Errorf("invalid peer id: %d", peerId)
+	}
+	host := t.hosts[peerId-1]
+	addr, err := utils.GetAddrFromHostname(host.Host, host.Port)
+	if err != nil {
+		return fmt.Errorf("error resolving address for host %s: %v", host.Host, err)
+	}
+	t.hostnames.Store(addr.String(), host.Name)
+	frame, err := EncodeFrame(Frame{
+		Type:     messageType,
+		SenderId: t.id,
+		Payload:  data,
+	})
+	if err != nil {
+		return fmt.Errorf("error framing message: %v", err)
+	}
+	return t.Egress.Send(addr, frame)
+}
+
+func (t *TCPTransport) Receive() <-chan types.InboundMessage {
+	return t.receive
+}
+
+// Close stops accepting connections and closes every pooled connection in
+// both directions. Frames that have not been received yet are dropped.
+func (t *TCPTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.listener.Close()
+		t.ingress.Close()
+		t.Egress.Close()
+	})
+	return err
+}
+
+func (t *TCPTransport) listen() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Println("Error accepting connection:", err)
+			continue
+		}
+		t.ingress.Add(conn.RemoteAddr(), conn)
+		go t.handleConnection(conn)
+	}
+}
+
+func (t *TCPTransport) handleConnection(conn net.Conn) {
+	defer t.ingress.Remove(conn.RemoteAddr())
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	tlsConn, _ := conn.(*tls.Conn)
+	verifiedId := 0
+
+	for {
+		frame, err := ReadFrame(reader)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				fmt.Println("Error reading frame from TCP connection:", err)
+			}
+			break
+		}
+		if tlsConn != nil && frame.SenderId != verifiedId {
+			if err := t.verifySender(tlsConn, frame.SenderId); err != nil {
+				fmt.Printf("Error authenticating peer %d on TLS connection from %v: %v\n", frame.SenderId, conn.RemoteAddr(), err)
+				break
+			}
+			verifiedId = frame.SenderId
+		}
+		select {
+		case t.receive <- types.InboundMessage{
+			Type:     frame.Type,
+			SenderId: frame.SenderId,
+			Data:     frame.Payload,
+			Sender:   conn.RemoteAddr(),
+		}:
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+// verifySender checks that the client certificate on conn belongs to the
+// peer with ID senderId.
+func (t *TCPTransport) verifySender(conn *tls.Conn, senderId int) error {
+	if senderId <= 0 || senderId > len(t.hosts) {
+		return fmt.Errorf("invalid peer id: %d", senderId)
+	}
+	return verifyPeerCertificate(conn, t.hosts[senderId-1].Name)
+}
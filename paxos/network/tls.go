@@ -0,0 +1,53 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// LoadTLSConfig builds the mutual TLS configuration shared by a peer's
+// listener and its outgoing connections. Both sides present the certificate
+// in certFile and only trust certificates signed by the CA in caFile.
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS CA: %v", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in TLS CA file %s", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      caPool,
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// GetTLSConnection dials addr and requires its certificate to be issued
+// for hostname.
+func GetTLSConnection(addr net.Addr, hostname string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	config = config.Clone()
+	config.ServerName = hostname
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr.String(), config)
+}
+
+// verifyPeerCertificate checks that the client certificate of conn was
+// issued for hostname.
+func verifyPeerCertificate(conn *tls.Conn, hostname string) error {
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return fmt.Errorf("no client certificate presented")
+	}
+	return certificates[0].VerifyHostname(hostname)
+}
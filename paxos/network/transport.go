@@ -0,0 +1,34 @@
+package network
+
+import (
+	"crypto/tls"
+
+	"paxos/paxos/config"
+	"paxos/paxos/types"
+)
+
+// Transport moves encoded messages between peers, which are addressed by
+// their 1-based position in the hosts file.
+type Transport interface {
+	Send(peerId int, messageType types.MessageType, data []byte) error
+	Receive() <-chan types.InboundMessage
+	Close() error
+}
+
+// TransportFactory creates the transport for the peer with the given ID.
+// hosts lists every peer of the cluster, in ID order.
+type TransportFactory func(id int, hosts []config.PeerConfig) (Transport, error)
+
+// NewTransportFactory returns the factory for a network transport whose
+// outgoing connections follow policy. A non-nil tlsConfig enables mutual TLS
+// on the TCP transport.
+func NewTransportFactory(protocol types.Protocol, policy ReconnectPolicy, tlsConfig *tls.Config) TransportFactory {
+	if protocol == types.UDP {
+		return func(id int, hosts []config.PeerConfig) (Transport, error) {
+			return NewUDPTransport(id, hosts, policy)
+		}
+	}
+	return func(id int, hosts []config.PeerConfig) (Transport, error) {
+		return NewTCPTransport(id, hosts, policy, tlsConfig)
+	}
+}
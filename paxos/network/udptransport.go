@@ -0,0 +1,116 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"paxos/paxos/config"
+	"paxos/paxos/types"
+	"paxos/paxos/utils"
+)
+
+// MaxDatagramSize is the largest UDP payload that fits in an IPv4 datagram.
+const MaxDatagramSize = 65507
+
+// UDPTransport sends each frame as a single datagram.
+type UDPTransport struct {
+	id        int
+	hosts     []config.PeerConfig
+	conn      net.PacketConn
+	Egress    *ConnectionPool
+	receive   chan types.InboundMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewUDPTransport(id int, hosts []config.PeerConfig, policy ReconnectPolicy) (*UDPTransport, error) {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", hosts[id-1].Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start UDP listener: %v", err)
+	}
+	t := &UDPTransport{
+		id:      id,
+		hosts:   hosts,
+		conn:    conn,
+		Egress:  NewUDPConnectionPool(Outgoing, policy),
+		receive: make(chan types.InboundMessage),
+		closed:  make(chan struct{}),
+	}
+	go t.listen()
+	return t, nil
+}
+
+func (t *UDPTransport) Send(peerId int, messageType types.MessageType, data []byte) error {
+	if peerId <= 0 || peerId > len(t.hosts) {
+		return fmt.Errorf("invalid peer id: %d", peerId)
+	}
+	host := t.hosts[peerId-1]
+	addr, err := utils.GetAddrFromHostname(host.Host, host.Port)
+	if err != nil {
+		return fmt.Errorf("error resolving address for host %s: %v", host.Host, err)
+	}
+	frame, err := EncodeFrame(Frame{
+		Type:     messageType,
+		SenderId: t.id,
+		Payload:  data,
+	})
+	if err != nil {
+		return fmt.Errorf("error framing message: %v", err)
+	}
+	if len(frame) > MaxDatagramSize {
+		return fmt.Errorf("frame of %d bytes does not fit in a UDP datagram", len(frame))
+	}
+	return t.Egress.Send(addr, frame)
+}
+
+func (t *UDPTransport) Receive() <-chan types.InboundMessage {
+	return t.receive
+}
+
+func (t *UDPTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.conn.Close()
+		t.Egress.Close()
+	})
+	return err
+}
+
+// listen reads one frame from each datagram. Datagrams that do not hold
+// exactly one well-formed frame are dropped.
+func (t *UDPTransport) listen() {
+	buffer := make([]byte, MaxDatagramSize)
+	for {
+		n, addr, err := t.conn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Println("Error reading from UDP socket:", err)
+			continue
+		}
+		reader := bytes.NewReader(buffer[:n])
+		frame, err := ReadFrame(reader)
+		if err == nil && reader.Len() > 0 {
+			err = fmt.Errorf("%d trailing bytes", reader.Len())
+		}
+		if err != nil {
+			fmt.Printf("Error reading frame from UDP datagram from %v: %v\n", addr, err)
+			continue
+		}
+		select {
+		case t.receive <- types.InboundMessage{
+			Type:     frame.Type,
+			SenderId: frame.SenderId,
+			Data:     frame.Payload,
+			Sender:   addr,
+		}:
+		case <-t.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package node embeds a Paxos peer in another program. A Node runs the
+// same protocol as the paxos binary, but hands chosen values to its caller
+// instead of logging them.
+package node
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"paxos/paxos/config"
+	"paxos/paxos/handlers"
+	"paxos/paxos/network"
+	"paxos/paxos/types"
+)
+
+// Value is a value chosen for a slot of a group's replicated log.
+type Value struct {
+	Group int
+	Slot  int
+	Data  []byte
+}
+
+type Node struct {
+	peer    *network.Peer
+	cancel  context.CancelFunc
+	done    chan struct{}
+	closed  sync.Once
+	err     error
+	mu      sync.Mutex
+	history []Value       // in the order they were chosen
+	updated chan struct{} // closed and replaced whenever history grows
+}
+
+// New starts the peer that cfg selects from cluster, as the paxos binary
+// would.
+func New(cfg *config.Config, cluster *config.ClusterConfig) (*Node, error) {
+	peer, err := network.NewPeer(cfg, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithPeer(peer), nil
+}
+
+// NewWithPeer starts a node around peer, which must not have been started.
+// Use it with network.NewPeerWithTransport to run a node over another
+// transport or storage.
+func NewWithPeer(peer *network.Peer) *Node {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Node{
+		peer:    peer,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		updated: make(chan struct{}),
+	}
+	peer.OnChosen = n.record
+	go peer.Start(ctx)
+	go func() {
+		defer close(n.done)
+		handlers.NewMessageHandler(peer).HandleMessages(ctx)
+	}()
+	return n
+}
+
+// Propose appends value to the replicated log and blocks until it has been
+// chosen. Other proposers' values may be chosen for earlier slots first.
+// If ctx is done before value is queued, Propose returns ctx.Err() and
+// value is never proposed. If ctx is done after that, Propose returns
+// ctx.Err(), but value stays queued and may still be chosen later.
+func (n *Node) Propose(ctx context.Context, value []byte) (Value, error) {
+	if n.peer.ProposerId == -1 {
+		return Value{}, fmt.Errorf("peer %d is not a proposer", n.peer.Id)
+	}
+	if n.peer.Stopped() {
+		return Value{}, fmt.Errorf("node is closed")
+	}
+	// A value is only queued if the caller still wants it.
+	if err := ctx.Err(); err != nil {
+		return Value{}, err
+	}
+	index := n.peer.Append(value)
+	select {
+	case slot := <-index:
+		return Value{Group: n.peer.Group, Slot: slot, Data: value}, nil
+	case <-ctx.Done():
+		return Value{}, ctx.Err()
+	case <-n.done:
+		return Value{}, fmt.Errorf("node is closed")
+	}
+}
+
+// Chosen returns the values this node knows were chosen, ordered by group
+// and then by slot. Nodes that are only acceptors never learn any.
+func (n *Node) Chosen() []Value {
+	var chosen []Value
+	n.peer.Log.Range(func(key, value any) bool {
+		logKey := key.(types.LogKey)
+		chosen = append(chosen, Value{Group: logKey.Group, Slot: logKey.Slot, Data: value.([]byte)})
+		return true
+	})
+	sort.Slice(chosen, func(i, j int) bool {
+		if chosen[i].Group != chosen[j].Group {
+			return chosen[i].Group < chosen[j].Group
+		}
+		return chosen[i].Slot < chosen[j].Slot
+	})
+	return chosen
+}
+
+// Subscribe returns a channel that receives every value this node learns
+// was chosen, starting with those chosen already, in the order the node
+// learned them. A learner may learn slots out of order. The channel is
+// closed when ctx is done or the node is closed; a subscriber that stops
+// reading holds up only its own channel.
+func (n *Node) Subscribe(ctx context.Context) <-chan Value {
+	values := make(chan Value)
+	go func() {
+		defer close(values)
+		next := 0
+		for {
+			n.mu.Lock()
+			pending := n.history[next:]
+			updated := n.updated
+			n.mu.Unlock()
+			for _, value := range pending {
+				select {
+				case values <- value:
+				case <-ctx.Done():
+					return
+				case <-n.done:
+					return
+				}
+			}
+			next += len(pending)
+			select {
+			case <-updated:
+			case <-ctx.Done():
+				return
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	return values
+}
+
+// Close stops the node once the messages it is handling have been sent,
+// then closes its connections and storage.
+func (n *Node) Close() error {
+	n.closed.Do(func() {
+		n.cancel()
+		<-n.done
+		n.err = n.peer.Close()
+	})
+	return n.err
+}
+
+func (n *Node) record(group int, slot int, data []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.history = append(n.history, Value{Group: group, Slot: slot, Data: data})
+	close(n.updated)
+	n.updated = make(chan struct{})
+}
+
+// Id returns the node's 1-based position in the cluster config.
+func (n *Node) Id() int {
+	return n.peer.Id
+}
@@ -0,0 +1,185 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"paxos/paxos/config"
+	"paxos/paxos/network"
+	"paxos/paxos/storage"
+)
+
+// startCluster runs every peer of hosts as a node on one in-memory network
+// and returns the nodes and their acceptor storage by name.
+func startCluster(t *testing.T, hosts string, codec string) (map[string]*Node, map[string]*storage.MemoryStorage) {
+	t.Helper()
+	cluster, err := config.ParseHostsFile([]byte(hosts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Codec:          codec,
+		PrepareTimeout: 500 * time.Millisecond,
+		AcceptTimeout:  500 * time.Millisecond,
+		BackoffMin:     10 * time.Millisecond,
+		BackoffMax:     100 * time.Millisecond,
+	}
+	memoryNetwork := network.NewMemoryNetwork()
+	nodes := make(map[string]*Node)
+	stores := make(map[string]*storage.MemoryStorage)
+	for _, name := range cluster.PeerNames() {
+		store := storage.NewMemoryStorage()
+		peer, err := network.NewPeerWithTransport(cfg, cluster, name, store, memoryNetwork.NewTransport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[name] = NewWithPeer(peer)
+		stores[name] = store
+	}
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.Close()
+		}
+	})
+	return nodes, stores
+}
+
+func TestSequentialPropose(t *testing.T) {
+	nodes, stores := startCluster(t, `peer1:proposer1
+peer2:acceptor1
+peer3:acceptor1
+peer4:acceptor1
+peer5:learner1
+`, "binary")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	learned := nodes["peer5"].Subscribe(ctx)
+
+	const count = 50
+	for i := 0; i < count; i++ {
+		data := []byte(fmt.Sprintf("value-%d", i))
+		value, err := nodes["peer1"].Propose(ctx, data)
+		if err != nil {
+			t.Fatalf("proposing %s: %v", data, err)
+		}
+		if value.Group != 1 || value.Slot != i+1 || string(value.Data) != string(data) {
+			t.Fatalf("%s was chosen at group %d slot %d, want group 1 slot %d", data, value.Group, value.Slot, i+1)
+		}
+	}
+
+	chosen := nodes["peer1"].Chosen()
+	if len(chosen) != count {
+		t.Fatalf("proposer knows %d chosen values, want %d", len(chosen), count)
+	}
+	for _, value := range chosen {
+		accepted := 0
+		for _, name := range []string{"peer2", "peer3", "peer4"} {
+			if _, data := stores[name].GetAccepted(1, value.Slot); string(data) == string(value.Data) {
+				accepted++
+			}
+		}
+		if accepted < 2 {
+			t.Errorf("slot %d: %q was accepted by %d acceptors, want a quorum", value.Slot, value.Data, accepted)
+		}
+	}
+
+	seen := make(map[int]string)
+	for len(seen) < count {
+		select {
+		case value := <-learned:
+			seen[value.Slot] = string(value.Data)
+		case <-ctx.Done():
+			t.Fatalf("learner learned %d of %d values", len(seen), count)
+		}
+	}
+	for _, value := range chosen {
+		if seen[value.Slot] != string(value.Data) {
+			t.Errorf("learner learned %q for slot %d, proposer chose %q", seen[value.Slot], value.Slot, value.Data)
+		}
+	}
+}
+
+func TestCompetingProposers(t *testing.T) {
+	for _, codec := range []string{"binary", "json"} {
+		t.Run(codec, func(t *testing.T) {
+			nodes, _ := startCluster(t, `peer1:proposer1.1
+peer2:acceptor1
+peer3:acceptor1
+peer4:acceptor1
+peer5:proposer1.2
+`, codec)
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			const count = 10
+			var mu sync.Mutex
+			slots := make(map[int]string)
+			var wg sync.WaitGroup
+			for _, name := range []string{"peer1", "peer5"} {
+				wg.Add(1)
+				go func(name string) {
+					defer wg.Done()
+					for i := 0; i < count; i++ {
+						data := fmt.Sprintf("%s-%d", name, i)
+						value, err := nodes[name].Propose(ctx, []byte(data))
+						if err != nil {
+							t.Errorf("%s proposing %s: %v", name, data, err)
+							return
+						}
+						mu.Lock()
+						if other, ok := slots[value.Slot]; ok {
+							t.Errorf("slot %d was reported chosen for both %s and %s", value.Slot, other, data)
+						}
+						slots[value.Slot] = data
+						mu.Unlock()
+					}
+				}(name)
+			}
+			wg.Wait()
+			if len(slots) != 2*count {
+				t.Errorf("%d values were chosen, want %d", len(slots), 2*count)
+			}
+
+			// Both proposers must agree on every slot they both decided.
+			decided := make(map[int]string)
+			for _, value := range nodes["peer1"].Chosen() {
+				decided[value.Slot] = string(value.Data)
+			}
+			for _, value := range nodes["peer5"].Chosen() {
+				if other, ok := decided[value.Slot]; ok && other != string(value.Data) {
+					t.Errorf("slot %d: peer1 chose %q, peer5 chose %q", value.Slot, other, value.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestProposeErrors(t *testing.T) {
+	nodes, _ := startCluster(t, `peer1:proposer1
+peer2:acceptor1
+peer3:acceptor1
+peer4:acceptor1
+`, "binary")
+	if _, err := nodes["peer2"].Propose(context.Background(), []byte("x")); err == nil {
+		t.Error("an acceptor accepted a proposal")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := nodes["peer1"].Propose(ctx, []byte("x")); err != context.Canceled {
+		t.Errorf("Propose with a cancelled context returned %v, want context.Canceled", err)
+	}
+	if pending := nodes["peer1"].peer.Pending.Length(); pending != 0 {
+		t.Errorf("Propose with a cancelled context queued the value, %d commands pending", pending)
+	}
+
+	if err := nodes["peer1"].Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodes["peer1"].Propose(context.Background(), []byte("x")); err == nil {
+		t.Error("a closed node accepted a proposal")
+	}
+}
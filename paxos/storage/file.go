@@ -0,0 +1,30 @@
+package storage
+
+// FileStorage keeps acceptor state in memory and appends every change to a
+// write-ahead log, which is replayed when the storage is reopened.
+type FileStorage struct {
+	*MemoryStorage
+	wal *WAL
+}
+
+func (fs *FileStorage) Close() error {
+	return fs.wal.Close()
+}
+
+func NewFileStorage(path string) (*FileStorage, error) {
+	wal, records, err := OpenWAL(path)
+	if err != nil {
+		return nil, err
+	}
+	memory := NewMemoryStorage()
+	for _, record := range records {
+		switch record.Kind {
+		case Promise:
+			memory.setPromise(record.Group, record.N)
+		case Accept:
+			memory.setAccepted(record.Group, record.Slot, record.N, record.Value)
+		}
+	}
+	memory.persist = wal.Append
+	return &FileStorage{MemoryStorage: memory, wal: wal}, nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"sync"
+)
+
+type instance struct {
+	group int
+	slot  int
+}
+
+type MemoryStorage struct {
+	promises sync.Map           // map[int]int64, keyed by group
+	accepted sync.Map           // map[instance]Accepted
+	highest  sync.Map           // map[int]int, highest accepted slot of each group
+	lock     sync.Mutex         // serializes Promise and Accept
+	persist  func(Record) error // if set, called before a change is applied
+}
+
+func (ms *MemoryStorage) GetPromise(group int) int64 {
+	value, ok := ms.promises.Load(group)
+	if !ok {
+		return 0
+	}
+	return value.(int64)
+}
+
+func (ms *MemoryStorage) Promise(group int, n int64) (int64, bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	promise := ms.GetPromise(group)
+	if n < promise {
+		return promise, false, nil
+	}
+	if n > promise {
+		if err := ms.write(Record{Kind: Promise, Group: group, N: n}); err != nil {
+			return promise, false, err
+		}
+		ms.setPromise(group, n)
+	}
+	return n, true, nil
+}
+
+func (ms *MemoryStorage) Accept(group int, slot int, n int64, value []byte) (int64, bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	promise := ms.GetPromise(group)
+	if n < promise {
+		return promise, false, nil
+	}
+	if acceptedN, acceptedValue := ms.GetAccepted(group, slot); acceptedN == n && !bytes.Equal(acceptedValue, value) {
+		return promise, false, nil
+	}
+	if n > promise {
+		if err := ms.write(Record{Kind: Promise, Group: group, N: n}); err != nil {
+			return promise, false, err
+		}
+		ms.setPromise(group, n)
+	}
+	if err := ms.write(Record{Kind: Accept, Group: group, Slot: slot, N: n, Value: value}); err != nil {
+		return n, false, err
+	}
+	ms.setAccepted(group, slot, n, value)
+	return n, true, nil
+}
+
+func (ms *MemoryStorage) write(record Record) error {
+	if ms.persist == nil {
+		return nil
+	}
+	return ms.persist(record)
+}
+
+func (ms *MemoryStorage) setPromise(group int, n int64) {
+	ms.promises.Store(group, n)
+}
+
+func (ms *MemoryStorage) GetAccepted(group int, slot int) (int64, []byte) {
+	value, ok := ms.accepted.Load(instance{group: group, slot: slot})
+	if !ok {
+		return 0, nil
+	}
+	accepted := value.(Accepted)
+	return accepted.N, accepted.Value
+}
+
+func (ms *MemoryStorage) setAccepted(group int, slot int, n int64, value []byte) {
+	ms.accepted.Store(instance{group: group, slot: slot}, Accepted{N: n, Value: value})
+	if slot > ms.HighestAcceptedSlot(group) {
+		ms.highest.Store(group, slot)
+	}
+}
+
+func (ms *MemoryStorage) HighestAcceptedSlot(group int) int {
+	value, ok := ms.highest.Load(group)
+	if !ok {
+		return 0
+	}
+	return value.(int)
+}
+
+func (ms *MemoryStorage) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		Promises: make(map[int]int64),
+		Accepted: make(map[int]map[int]Accepted),
+	}
+	ms.promises.Range(func(group, n any) bool {
+		snapshot.Promises[group.(int)] = n.(int64)
+		return true
+	})
+	ms.accepted.Range(func(key, accepted any) bool {
+		instance := key.(instance)
+		if snapshot.Accepted[instance.group] == nil {
+			snapshot.Accepted[instance.group] = make(map[int]Accepted)
+		}
+		snapshot.Accepted[instance.group][instance.slot] = accepted.(Accepted)
+		return true
+	})
+	return snapshot
+}
+
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
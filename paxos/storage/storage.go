@@ -0,0 +1,33 @@
+package storage
+
+// Storage holds an acceptor's durable state for each proposer group it
+// accepts for: the highest proposal number it has promised, and the proposal
+// it has accepted in each slot. Promise and Accept check and change that
+// state atomically, and do not return until the change would survive a
+// restart.
+type Storage interface {
+	GetPromise(group int) int64
+	GetAccepted(group int, slot int) (int64, []byte)
+	// Promise promises n unless group has a higher promise. It returns the
+	// promise in force afterwards and whether n was promised.
+	Promise(group int, n int64) (int64, bool, error)
+	// Accept accepts value for slot at n unless group has a higher promise
+	// or slot already holds a different value at n. It returns the promise
+	// in force afterwards and whether value was accepted.
+	Accept(group int, slot int, n int64, value []byte) (int64, bool, error)
+	// HighestAcceptedSlot returns the highest slot of group that holds an
+	// accepted proposal, or 0 if none does.
+	HighestAcceptedSlot(group int) int
+	Snapshot() Snapshot
+	Close() error
+}
+
+type Accepted struct {
+	N     int64
+	Value []byte
+}
+
+type Snapshot struct {
+	Promises map[int]int64            // keyed by group
+	Accepted map[int]map[int]Accepted // keyed by group, then slot
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestPromise(t *testing.T) {
+	ms := NewMemoryStorage()
+	if promise, ok, err := ms.Promise(1, 5); promise != 5 || !ok || err != nil {
+		t.Fatalf("Promise(1, 5) = %d, %v, %v; want 5, true, nil", promise, ok, err)
+	}
+	if promise, ok, _ := ms.Promise(1, 5); promise != 5 || !ok {
+		t.Errorf("repeated Promise(1, 5) = %d, %v; want 5, true", promise, ok)
+	}
+	if promise, ok, _ := ms.Promise(1, 3); promise != 5 || ok {
+		t.Errorf("Promise(1, 3) after 5 = %d, %v; want 5, false", promise, ok)
+	}
+	if promise, ok, _ := ms.Promise(2, 3); promise != 3 || !ok {
+		t.Errorf("Promise(2, 3) = %d, %v; want 3, true, groups are independent", promise, ok)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	ms := NewMemoryStorage()
+	ms.Promise(1, 5)
+	if promise, ok, _ := ms.Accept(1, 1, 3, []byte("old")); promise != 5 || ok {
+		t.Errorf("Accept below the promise = %d, %v; want 5, false", promise, ok)
+	}
+	if _, ok, _ := ms.Accept(1, 1, 5, []byte("a")); !ok {
+		t.Fatal("Accept at the promise was refused")
+	}
+	if _, ok, _ := ms.Accept(1, 1, 5, []byte("a")); !ok {
+		t.Error("Accept of the same value at the same ballot was refused")
+	}
+	if _, ok, _ := ms.Accept(1, 1, 5, []byte("b")); ok {
+		t.Error("Accept of a second value at the same ballot was allowed")
+	}
+	if n, value := ms.GetAccepted(1, 1); n != 5 || string(value) != "a" {
+		t.Errorf("GetAccepted(1, 1) = %d, %q; want 5, \"a\"", n, value)
+	}
+	if promise, ok, _ := ms.Accept(1, 2, 7, []byte("c")); promise != 7 || !ok {
+		t.Errorf("Accept above the promise = %d, %v; want 7, true", promise, ok)
+	}
+	if promise := ms.GetPromise(1); promise != 7 {
+		t.Errorf("Accept above the promise left the promise at %d, want 7", promise)
+	}
+}
+
+func TestHighestAcceptedSlot(t *testing.T) {
+	ms := NewMemoryStorage()
+	if slot := ms.HighestAcceptedSlot(1); slot != 0 {
+		t.Errorf("HighestAcceptedSlot(1) = %d with nothing accepted, want 0", slot)
+	}
+	ms.Accept(1, 3, 5, []byte("a"))
+	ms.Accept(1, 1, 5, []byte("b"))
+	ms.Accept(2, 7, 5, []byte("c"))
+	ms.Accept(1, 9, 4, []byte("refused"))
+	if slot := ms.HighestAcceptedSlot(1); slot != 3 {
+		t.Errorf("HighestAcceptedSlot(1) = %d, want 3", slot)
+	}
+	if slot := ms.HighestAcceptedSlot(2); slot != 7 {
+		t.Errorf("HighestAcceptedSlot(2) = %d, want 7", slot)
+	}
+}
+
+func TestConcurrentPromisesNeverLowerThePromise(t *testing.T) {
+	ms := NewMemoryStorage()
+	var wg sync.WaitGroup
+	for n := int64(1); n <= 100; n++ {
+		wg.Add(1)
+		go func(n int64) {
+			defer wg.Done()
+			ms.Promise(1, n)
+		}(n)
+	}
+	wg.Wait()
+	if promise := ms.GetPromise(1); promise != 100 {
+		t.Errorf("promise is %d after promising 1 to 100, want 100", promise)
+	}
+}
+
+func TestFailedWritesChangeNothing(t *testing.T) {
+	ms := NewMemoryStorage()
+	ms.Promise(1, 2)
+	ms.persist = func(Record) error { return errors.New("disk full") }
+	if _, ok, err := ms.Promise(1, 4); ok || err == nil {
+		t.Errorf("Promise with a failing log = %v, %v; want false and an error", ok, err)
+	}
+	if _, ok, err := ms.Accept(1, 1, 4, []byte("a")); ok || err == nil {
+		t.Errorf("Accept with a failing log = %v, %v; want false and an error", ok, err)
+	}
+	if promise := ms.GetPromise(1); promise != 2 {
+		t.Errorf("promise is %d after failed writes, want 2", promise)
+	}
+	if n, value := ms.GetAccepted(1, 1); n != 0 || value != nil {
+		t.Errorf("GetAccepted(1, 1) = %d, %q after a failed write, want nothing", n, value)
+	}
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sync"
+
+	"paxos/paxos/types"
+	"paxos/paxos/utils"
+)
+
+type RecordKind int
+
+const (
+	Promise RecordKind = iota
+	Accept
+)
+
+// A log starts with walMagic and walVersion, so that a log in an older
+// layout is refused rather than misread. Version 2 added the group to every
+// record, version 3 the checksums.
+const (
+	walMagic   = 0x4c575850 // "PXWL"
+	walVersion = 3
+)
+
+// A record is its header, the words of its value and a checksum of both. The
+// header is kind, group, slot, the two halves of the proposal number, the
+// value's length and a checksum of those six integers, so that a corrupt
+// length is caught before it is used to find the end of the record.
+const (
+	headerSize     = 7
+	lengthIndex    = 5
+	checksumLength = 1
+)
+
+type Record struct {
+	Kind  RecordKind
+	Group int
+	Slot  int
+	N     int64
+	Value []byte
+}
+
+type WAL struct {
+	file *os.File
+	lock sync.Mutex
+}
+
+// OpenWAL opens the log at path, creating it if needed, and returns the
+// records already written to it. A torn record at the end of the log, left
+// by a crash in the middle of a write, is discarded. Any other damage is an
+// error, since dropping the records after it would forget promises.
+func OpenWAL(path string) (*WAL, []Record, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	integers, err := types.Deserialize(content[:len(content)-len(content)%4])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding write-ahead log: %v", err)
+	}
+
+	if len(integers) >= 2 && (integers[0] != walMagic || integers[1] != walVersion) {
+		if integers[0] != walMagic {
+			return nil, nil, fmt.Errorf("%s is not a versioned write-ahead log; logs written before version %d cannot be read", path, walVersion)
+		}
+		return nil, nil, fmt.Errorf("unsupported write-ahead log version %d, expected %d", integers[1], walVersion)
+	}
+
+	var records []Record
+	valid := 2
+	for valid < len(integers) {
+		record, end, err := readRecord(content, integers, valid)
+		if err == errTorn {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("write-ahead log %s is corrupt at offset %d: %v", path, valid*4, err)
+		}
+		records = append(records, record)
+		valid = end
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(integers) < 2 {
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		if _, err := file.Write(types.Serialize(walMagic, walVersion)); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	if err := file.Truncate(int64(valid * 4)); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(int64(valid*4), 0); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return &WAL{file: file}, records, nil
+}
+
+var errTorn = errors.New("torn record")
+
+// readRecord decodes the record starting at integers[start] and returns it
+// with the index just past it. It returns errTorn if the record was cut
+// short by the end of the log, which only a crash while appending it can
+// cause.
+func readRecord(content []byte, integers []int, start int) (Record, int, error) {
+	if start+headerSize > len(integers) {
+		return Record{}, 0, errTorn
+	}
+	header := content[start*4 : (start+headerSize-1)*4]
+	if uint32(int32(integers[start+headerSize-1])) != crc32.ChecksumIEEE(header) {
+		if allZero(content[start*4:]) {
+			// The file grew before the record's bytes reached the disk.
+			return Record{}, 0, errTorn
+		}
+		return Record{}, 0, fmt.Errorf("record header checksum mismatch")
+	}
+	length := integers[start+lengthIndex]
+	if length < 0 {
+		return Record{}, 0, fmt.Errorf("negative value length %d", length)
+	}
+	words := start + headerSize + (length+3)/4
+	end := words + checksumLength
+	if end > len(integers) {
+		return Record{}, 0, errTorn
+	}
+	if uint32(int32(integers[words])) != crc32.ChecksumIEEE(content[start*4:words*4]) {
+		if end == len(integers) {
+			return Record{}, 0, errTorn
+		}
+		return Record{}, 0, fmt.Errorf("record checksum mismatch")
+	}
+	value, err := types.DecodeValue(append([]int{length}, integers[start+headerSize:words]...))
+	if err != nil {
+		return Record{}, 0, err
+	}
+	return Record{
+		Kind:  RecordKind(integers[start]),
+		Group: integers[start+1],
+		Slot:  integers[start+2],
+		N:     utils.GetN(int32(integers[start+3]), int32(integers[start+4])),
+		Value: value,
+	}, end, nil
+}
+
+func allZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Append writes the record and fsyncs it before returning.
+func (w *WAL) Append(record Record) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	roundNumber, serverId := utils.SplitN(record.N)
+	value := types.EncodeValue(record.Value)
+	header := types.Serialize(int(record.Kind), record.Group, record.Slot, int(roundNumber), int(serverId), value[0])
+	data := append(header, types.Serialize(int(int32(crc32.ChecksumIEEE(header))))...)
+	data = append(data, types.Serialize(value[1:]...)...)
+	data = append(data, types.Serialize(int(int32(crc32.ChecksumIEEE(data))))...)
+	if _, err := w.file.Write(data); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close fsyncs and closes the log. Appends fail afterwards.
+func (w *WAL) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return errors.Join(w.file.Sync(), w.file.Close())
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorageReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acceptor.wal")
+	fs, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Promise(1, 4)
+	fs.Accept(1, 1, 4, []byte("a"))
+	fs.Accept(2, 3, 6, []byte("b"))
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if promise := fs.GetPromise(1); promise != 4 {
+		t.Errorf("reopened promise for group 1 is %d, want 4", promise)
+	}
+	if promise := fs.GetPromise(2); promise != 6 {
+		t.Errorf("reopened promise for group 2 is %d, want 6", promise)
+	}
+	if n, value := fs.GetAccepted(1, 1); n != 4 || string(value) != "a" {
+		t.Errorf("reopened GetAccepted(1, 1) = %d, %q; want 4, \"a\"", n, value)
+	}
+	if n, value := fs.GetAccepted(2, 3); n != 6 || string(value) != "b" {
+		t.Errorf("reopened GetAccepted(2, 3) = %d, %q; want 6, \"b\"", n, value)
+	}
+	if slot := fs.HighestAcceptedSlot(2); slot != 3 {
+		t.Errorf("reopened HighestAcceptedSlot(2) = %d, want 3", slot)
+	}
+	if _, ok, _ := fs.Accept(1, 1, 4, []byte("c")); ok {
+		t.Error("reopened storage accepted a second value at the same ballot")
+	}
+}
+
+func TestFileStorageDiscardsTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acceptor.wal")
+	fs, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Accept(1, 1, 4, []byte("a"))
+	fs.Accept(1, 2, 4, []byte("b"))
+	fs.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	fs, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if n, value := fs.GetAccepted(1, 1); n != 4 || string(value) != "a" {
+		t.Errorf("GetAccepted(1, 1) = %d, %q; want 4, \"a\"", n, value)
+	}
+	if n, _ := fs.GetAccepted(1, 2); n != 0 {
+		t.Errorf("torn record for slot 2 was replayed at %d", n)
+	}
+}
+
+func TestFileStorageRejectsUnversionedLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acceptor.wal")
+	if err := os.WriteFile(path, []byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStorage(path); err == nil {
+		t.Error("opened a log without a version header")
+	}
+}
+
+// writeLog fills a log at a new path with a promise and two accepts and
+// returns the path.
+func writeLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acceptor.wal")
+	fs, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Promise(1, 4)
+	fs.Accept(1, 1, 4, []byte("first"))
+	fs.Accept(1, 2, 4, []byte("second"))
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileStorageRejectsCorruptRecords(t *testing.T) {
+	// Offsets into the first record, which follows the 8-byte log header:
+	// its slot, its value length and its checksum.
+	for _, offset := range []int{16, 28, 36} {
+		path := writeLog(t)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content[offset] ^= 0x40
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFileStorage(path); err == nil {
+			t.Errorf("opened a log with a corrupt byte at offset %d", offset)
+		}
+	}
+}
+
+func TestFileStorageDiscardsZeroedTail(t *testing.T) {
+	path := writeLog(t)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(make([]byte, 40))
+	file.Close()
+
+	fs, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if _, value := fs.GetAccepted(1, 2); string(value) != "second" {
+		t.Errorf("GetAccepted(1, 2) = %q after a zeroed tail, want \"second\"", value)
+	}
+	if _, ok, err := fs.Accept(1, 3, 4, []byte("third")); !ok || err != nil {
+		t.Fatalf("Accept after discarding the tail = %v, %v", ok, err)
+	}
+}
+
+func TestFileStorageRejectsOlderVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acceptor.wal")
+	if err := os.WriteFile(path, []byte{0x50, 0x58, 0x57, 0x4c, 2, 0, 0, 0}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStorage(path); err == nil {
+		t.Error("opened a version 2 log")
+	}
+}
@@ -17,6 +17,17 @@ const (
 	UDP
 )
 
+func ParseProtocol(name string) (Protocol, error) {
+	switch name {
+	case "tcp":
+		return TCP, nil
+	case "udp":
+		return UDP, nil
+	default:
+		return 0, fmt.Errorf("unknown transport: %s", name)
+	}
+}
+
 type MessageType int
 
 const (
@@ -24,6 +35,9 @@ const (
 	PREPARE_ACK
 	ACCEPT
 	ACCEPT_ACK
+	LEARN
+	PREPARE_NACK
+	ACCEPT_NACK
 )
 
 type Role int
@@ -35,37 +49,93 @@ const (
 )
 
 type InboundMessage struct {
-	Data   []byte
-	Sender net.Addr
+	Type     MessageType
+	SenderId int
+	Data     []byte
+	Sender   net.Addr
 }
 
 type OutboundMessage struct {
-	Data      []byte
-	Recipient net.Addr
+	Type        MessageType
+	Data        []byte
+	RecipientId int
 }
 
 type ProposalNumber struct {
-	RoundNumber *datastructures.SafeValue[int]
-	ServerId    *datastructures.SafeValue[int]
+	RoundNumber *datastructures.SafeValue[int] `json:"round_number"`
+	ServerId    *datastructures.SafeValue[int] `json:"server_id"`
+}
+
+type Command struct {
+	Value []byte
+	Index chan int
+}
+
+// Proposal is what a proposer sent in one accept: the value, and the pending
+// command it came from, or nil if the value was adopted from an acceptor.
+type Proposal struct {
+	Value   []byte
+	Command *Command
+}
+
+// LogKey identifies a slot of one group's replicated log.
+type LogKey struct {
+	Group int
+	Slot  int
+}
+
+type InstanceKey struct {
+	Group int
+	Slot  int
+	N     int64
 }
 
 type PrepareMessage struct {
-	ProposalNumber *ProposalNumber
-	ProposalValue  *datastructures.SafeValue[rune]
+	Group          *datastructures.SafeValue[int]    `json:"group"`
+	Slot           *datastructures.SafeValue[int]    `json:"slot"`
+	ProposalNumber *ProposalNumber                   `json:"proposal_number"`
+	ProposalValue  *datastructures.SafeValue[[]byte] `json:"proposal_value"`
 }
 
 type PrepareAckMessage struct {
-	AcceptedProposalNumber *ProposalNumber
-	AcceptedValue          *datastructures.SafeValue[rune]
+	Slot                   *datastructures.SafeValue[int]    `json:"slot"`
+	ProposalNumber         *ProposalNumber                   `json:"proposal_number"`
+	AcceptedProposalNumber *ProposalNumber                   `json:"accepted_proposal_number"`
+	AcceptedValue          *datastructures.SafeValue[[]byte] `json:"accepted_value"`
+	NoMoreAccepted         *datastructures.SafeValue[bool]   `json:"no_more_accepted"`
 }
 
 type AcceptMessage struct {
-	ProposalNumber *ProposalNumber
-	ProposalValue  *datastructures.SafeValue[rune]
+	Group          *datastructures.SafeValue[int]    `json:"group"`
+	Slot           *datastructures.SafeValue[int]    `json:"slot"`
+	ProposalNumber *ProposalNumber                   `json:"proposal_number"`
+	ProposalValue  *datastructures.SafeValue[[]byte] `json:"proposal_value"`
+}
+
+type PrepareNackMessage struct {
+	Slot                   *datastructures.SafeValue[int] `json:"slot"`
+	ProposalNumber         *ProposalNumber                `json:"proposal_number"`
+	PromisedProposalNumber *ProposalNumber                `json:"promised_proposal_number"`
 }
 
+type AcceptNackMessage struct {
+	Slot                   *datastructures.SafeValue[int] `json:"slot"`
+	ProposalNumber         *ProposalNumber                `json:"proposal_number"`
+	PromisedProposalNumber *ProposalNumber                `json:"promised_proposal_number"`
+}
+
+type LearnMessage struct {
+	Group          *datastructures.SafeValue[int]    `json:"group"`
+	Slot           *datastructures.SafeValue[int]    `json:"slot"`
+	ProposalNumber *ProposalNumber                   `json:"proposal_number"`
+	AcceptedValue  *datastructures.SafeValue[[]byte] `json:"accepted_value"`
+}
+
+// AcceptAckMessage and PrepareAckMessage carry the proposal number they
+// answer, so that a delayed ack is never counted towards a later round.
 type AcceptAckMessage struct {
-	ProposalNumber *ProposalNumber
+	Slot           *datastructures.SafeValue[int] `json:"slot"`
+	ProposalNumber *ProposalNumber                `json:"proposal_number"`
 }
 
 func Serialize(integers ...int) []byte {
@@ -80,6 +150,34 @@ func Serialize(integers ...int) []byte {
 	return buffer.Bytes()
 }
 
+// EncodeValue packs a value into integers so that it can be serialized after
+// a message's fixed fields: the value's length followed by its bytes, four
+// to an integer.
+func EncodeValue(value []byte) []int {
+	integers := []int{len(value)}
+	for i := 0; i < len(value); i += 4 {
+		var word [4]byte
+		copy(word[:], value[i:])
+		integers = append(integers, int(int32(binary.LittleEndian.Uint32(word[:]))))
+	}
+	return integers
+}
+
+func DecodeValue(integers []int) ([]byte, error) {
+	if len(integers) == 0 {
+		return nil, fmt.Errorf("missing value length")
+	}
+	length := integers[0]
+	if length < 0 || (length+3)/4 != len(integers)-1 {
+		return nil, fmt.Errorf("value length %d does not match %d encoded words", length, len(integers)-1)
+	}
+	value := make([]byte, 0, length+3)
+	for _, integer := range integers[1:] {
+		value = binary.LittleEndian.AppendUint32(value, uint32(int32(integer)))
+	}
+	return value[:length], nil
+}
+
 func Deserialize(data []byte) ([]int, error) {
 	var integers []int
 	buffer := bytes.NewReader(data)
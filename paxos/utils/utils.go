@@ -2,128 +2,13 @@ package utils
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
-
-	"paxos/paxos/types"
+	"time"
 )
 
-func GetPeers(hostsfile string) ([]string, error) {
-	content, err := os.ReadFile(hostsfile)
-	if err != nil {
-		return nil, err
-	}
-	lines := strings.Split(string(content), "\n")
-	var peers []string
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-		parts := strings.Split(line, ":")
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("invalid format in hostsfile: %s", line)
-		}
-		peers = append(peers, parts[0])
-	}
-	return peers, nil
-}
-
-func GetProposerId(peer string, hostsfile string) (int, error) {
-	content, err := os.ReadFile(hostsfile)
-	if err != nil {
-		return 0, err
-	}
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, peer+":") {
-			parts := strings.Split(line, ":")
-			if len(parts) < 2 {
-				return 0, fmt.Errorf("invalid format in hostsfile: %s", line)
-			}
-			roles := strings.Split(parts[1], ",")
-			for _, role := range roles {
-				if strings.HasPrefix(role, "proposer") {
-					proposerIdStr := strings.TrimPrefix(role, "proposer")
-					proposerId, err := strconv.Atoi(proposerIdStr)
-					if err != nil {
-						return 0, fmt.Errorf("invalid proposer id: %s", proposerIdStr)
-					}
-					return proposerId, nil
-				}
-			}
-		}
-	}
-	return 0, fmt.Errorf("proposer id not found for peer: %s", peer)
-}
-
-func GetRoles(peer string, hostsfile string) ([]types.Role, error) {
-	content, err := os.ReadFile(hostsfile)
-	if err != nil {
-		return nil, err
-	}
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, peer+":") {
-			parts := strings.Split(line, ":")
-			if len(parts) < 2 {
-				return nil, fmt.Errorf("invalid format in hostsfile: %s", line)
-			}
-			roleStrs := strings.Split(parts[1], ",")
-			var roles []types.Role
-			for _, roleStr := range roleStrs {
-				switch {
-				case strings.HasPrefix(roleStr, "proposer"):
-					roles = append(roles, types.Proposer)
-				case strings.HasPrefix(roleStr, "acceptor"):
-					roles = append(roles, types.Acceptor)
-				case strings.HasPrefix(roleStr, "learner"):
-					roles = append(roles, types.Learner)
-				default:
-					return nil, fmt.Errorf("unknown role: %s", roleStr)
-				}
-			}
-			return roles, nil
-		}
-	}
-	return nil, fmt.Errorf("roles not found for peer: %s", peer)
-}
-
-func GetAcceptorsForProposer(proposerId int, hostsfile string) ([]string, error) {
-	content, err := os.ReadFile(hostsfile)
-	if err != nil {
-		return nil, err
-	}
-	lines := strings.Split(string(content), "\n")
-	var acceptors []string
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-		parts := strings.Split(line, ":")
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("invalid format in hostsfile: %s", line)
-		}
-		roles := strings.Split(parts[1], ",")
-		for _, role := range roles {
-			if strings.HasPrefix(role, "acceptor") {
-				acceptorProposerIdStr := strings.TrimPrefix(role, "acceptor")
-				acceptorProposerId, err := strconv.Atoi(acceptorProposerIdStr)
-				if err != nil {
-					return nil, fmt.Errorf("invalid acceptor proposer id: %s", acceptorProposerIdStr)
-				}
-				if acceptorProposerId == proposerId {
-					acceptors = append(acceptors, parts[0])
-					break
-				}
-			}
-		}
-	}
-	return acceptors, nil
-}
-
 func GetPeerIdFromName(peer string, peers []string) (int, error) {
 	for i, p := range peers {
 		if p == peer {
@@ -140,7 +25,7 @@ func GetPeerNameFromId(id int, peers []string) (string, error) {
 	return peers[id-1], nil
 }
 
-func GetAddrFromHostname(hostname string) (net.Addr, error) {
+func GetAddrFromHostname(hostname string, port int) (net.Addr, error) {
 	addrs, err := net.LookupIP(hostname)
 	if err != nil {
 		return nil, err
@@ -148,30 +33,7 @@ func GetAddrFromHostname(hostname string) (net.Addr, error) {
 	if len(addrs) == 0 {
 		return nil, fmt.Errorf("no addresses found for hostname: %s", hostname)
 	}
-	return &net.TCPAddr{IP: addrs[0]}, nil
-}
-
-func GetHostnameFromAddr(addr net.Addr) (string, error) {
-	ip, _, err := net.SplitHostPort(addr.String())
-	if err != nil {
-		return "", err
-	}
-
-	names, err := net.LookupAddr(ip)
-	if err != nil {
-		return "", err
-	}
-	if len(names) == 0 {
-		return "", fmt.Errorf("no hostnames found for IP: %s", ip)
-	}
-	return names[0], nil
-}
-
-func CleanHostname(hostname string) string {
-	if idx := strings.Index(hostname, "."); idx != -1 {
-		return hostname[:idx]
-	}
-	return hostname
+	return &net.TCPAddr{IP: addrs[0], Port: port}, nil
 }
 
 func RemoveSelf(peers []string, self string) ([]string, error) {
@@ -184,6 +46,30 @@ func RemoveSelf(peers []string, self string) ([]string, error) {
 	return peersWithoutSelf, nil
 }
 
+// GetQuorumSize returns override when it is set, and a strict majority of
+// the acceptor group otherwise.
+func GetQuorumSize(acceptors int, override int) int {
+	if override > 0 {
+		return override
+	}
+	return acceptors/2 + 1
+}
+
+// ValidateQuorums checks that every phase-1 quorum intersects every
+// phase-2 quorum of a group with the given number of acceptors.
+func ValidateQuorums(acceptors int, prepareQuorum int, acceptQuorum int) error {
+	if acceptors == 0 {
+		return fmt.Errorf("no acceptors")
+	}
+	if prepareQuorum > acceptors || acceptQuorum > acceptors {
+		return fmt.Errorf("quorum larger than %d acceptors", acceptors)
+	}
+	if prepareQuorum+acceptQuorum <= acceptors {
+		return fmt.Errorf("prepare quorum %d and accept quorum %d do not intersect across %d acceptors", prepareQuorum, acceptQuorum, acceptors)
+	}
+	return nil
+}
+
 func GetN(high, low int32) int64 {
 	result := int64(high) << 32
 	result |= int64(uint32(low))
@@ -208,3 +94,19 @@ func Length(m *sync.Map) int {
 func PrintToStderr(message string) {
 	fmt.Fprintln(os.Stderr, message)
 }
+
+// Backoff returns a random delay in [min, ceiling], where ceiling starts at
+// min and doubles with every attempt after the first, up to max.
+func Backoff(min, max time.Duration, attempts int) time.Duration {
+	ceiling := min
+	for i := 1; i < attempts && ceiling < max; i++ {
+		ceiling *= 2
+	}
+	if ceiling > max {
+		ceiling = max
+	}
+	if ceiling <= min {
+		return min
+	}
+	return min + time.Duration(rand.Int63n(int64(ceiling-min)+1))
+}
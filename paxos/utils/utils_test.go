@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	min, max := 10*time.Millisecond, 80*time.Millisecond
+	for _, test := range []struct {
+		attempts int
+		ceiling  time.Duration
+	}{
+		{0, min},
+		{1, min},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, max},
+		{40, max},
+	} {
+		for i := 0; i < 100; i++ {
+			if delay := Backoff(min, max, test.attempts); delay < min || delay > test.ceiling {
+				t.Fatalf("Backoff(%v, %v, %d) = %v, want within [%v, %v]", min, max, test.attempts, delay, min, test.ceiling)
+			}
+		}
+	}
+	if delay := Backoff(max, min, 5); delay != max {
+		t.Errorf("Backoff with max below min = %v, want min %v", delay, max)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"paxos/paxos/config"
+)
+
+// validate implements `paxos validate`, which reports every problem in a
+// hosts file or cluster config and returns the process exit status.
+func validate(args []string) int {
+	cfg := config.ParseValidateFlags(args)
+	if cfg == nil {
+		return 2
+	}
+
+	cluster, problems := config.CheckClusterConfig(cfg.HostsFile, cfg.PrepareQuorumSize, cfg.AcceptQuorumSize)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cfg.HostsFile, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+
+	fmt.Printf("%s: OK, %d peers in %d groups\n", cfg.HostsFile, len(cluster.Peers), len(cluster.Groups))
+	return 0
+}